@@ -1,12 +1,35 @@
 package aicraft
 
+import (
+	"context"
+	"sync"
+)
+
 type Agent struct {
 	ID        string
 	Name      string
 	Tasks     []*Task
 	DependsOn []string
-	Output    map[string]interface{}
-	Stream    <-chan interface{}
+	// Condition is an expression over the results of the tasks of the
+	// agents it depends on, directly or not, that must hold for the agent to
+	// run, e.g. len(check_images) > 0. See RouterTool for picking one of
+	// several branches.
+	Condition string
+	// Skipped is set on the agents of a Run that were not executed because
+	// their condition did not hold or all their dependencies were skipped.
+	Skipped bool
+	// An agent with MaxIterations above 1 runs its tasks in a loop, at most
+	// MaxIterations times, until the expression Until holds for the results
+	// of its tasks and those Condition can see. Iterations holds the Output
+	// of every iteration of a loop the run executed; a resumed run continues
+	// the loop in the iteration it stopped in.
+	Until         string
+	MaxIterations int
+	Iterations    []map[string]interface{}
+	Output        map[string]interface{}
+	waitOnce      *sync.Once
+	waitErr       error
+	usage         usageMeter
 }
 
 func NewAgent(id, name string, dependsOn []string) *Agent {
@@ -16,6 +39,7 @@ func NewAgent(id, name string, dependsOn []string) *Agent {
 		Tasks:     []*Task{},
 		DependsOn: dependsOn,
 		Output:    make(map[string]interface{}),
+		waitOnce:  &sync.Once{},
 	}
 }
 
@@ -23,14 +47,46 @@ func (a *Agent) AddTask(task *Task) {
 	a.Tasks = append(a.Tasks, task)
 }
 
-func (a *Agent) ExecuteTasks() error {
+func (a *Agent) ExecuteTasks(ctx context.Context) error {
+	a.usage.attach(usageMeterFromContext(ctx))
+	ctx = withUsageMeter(ctx, &a.usage)
+	return a.runTasks(func(task *Task) error {
+		return task.Execute(ctx)
+	})
+}
+
+func (a *Agent) runTasks(execute func(*Task) error) error {
+	a.waitOnce = &sync.Once{}
+	a.waitErr = nil
 	for _, task := range a.Tasks {
-		err := task.Execute()
+		err := execute(task)
 		if err != nil {
 			return err
 		}
-		a.Output[task.ID] = task.Result
-		a.Stream = task.Stream
+		if task.Stream == nil {
+			a.Output[task.ID] = task.Result
+		}
 	}
 	return nil
 }
+
+// Usage returns the tokens used by the agent's tasks.
+func (a *Agent) Usage() TokenUsage {
+	return a.usage.total()
+}
+
+// Wait blocks until the streams of all the agent's tasks have ended, stores
+// the streamed text in Output and returns the first stream error.
+func (a *Agent) Wait() error {
+	a.waitOnce.Do(func() {
+		for _, task := range a.Tasks {
+			if task.Stream != nil {
+				if err := task.Wait(); err != nil && a.waitErr == nil {
+					a.waitErr = err
+				}
+				a.Output[task.ID] = task.Result
+			}
+		}
+	})
+	return a.waitErr
+}
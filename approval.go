@@ -0,0 +1,226 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrApprovalNotFound is returned by Decide when the task is not waiting for
+// an approval.
+var ErrApprovalNotFound = errors.New("no pending approval")
+
+// ErrRejected is returned by approval tasks whose item was rejected.
+var ErrRejected = errors.New("rejected")
+
+// ErrRunExecuting is returned by Decide when the stored run is executing in
+// another process, which would overwrite the decision with its next
+// checkpoint.
+var ErrRunExecuting = errors.New("run is executing")
+
+// Approval is an item a task of a run is waiting for a person to approve.
+// TaskID is the path of the task in a sub-workflow, like Event.Parent.
+type Approval struct {
+	RunID       string      `json:"run_id"`
+	TaskID      string      `json:"task_id"`
+	Message     string      `json:"message,omitempty"`
+	Value       interface{} `json:"value"`
+	RequestedAt time.Time   `json:"requested_at"`
+}
+
+// Decision answers an Approval. When an approved decision has a Value, it
+// replaces the submitted value as the result of the task.
+type Decision struct {
+	Approved bool        `json:"approved"`
+	Value    interface{} `json:"value,omitempty"`
+	Comment  string      `json:"comment,omitempty"`
+}
+
+// ApprovalTool pauses the run until a person decides on its 'value' input,
+// typically filled with InputsFrom. The task publishes an ApprovalRequested
+// event, is listed by Manager.Approvals and waits for Manager.Decide. Its
+// result is the approved value, or the edited value of the decision; a
+// rejection fails the task with ErrRejected.
+var ApprovalTool = &Tool{
+	ID:   "approval",
+	Name: "Approval",
+	Inputs: []ToolInput{
+		{Name: "value", Type: "object", Required: true, Description: "the item to approve"},
+		{Name: "message", Type: "string", Description: "what the reviewer should check"},
+	},
+	Estimate: noUsage,
+	ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		scope, ok := ctx.Value(runTaskKey{}).(runTask)
+		if !ok {
+			return nil, nil, fmt.Errorf("approval tasks can only run in a workflow run")
+		}
+		value, ok := inputs["value"]
+		if !ok {
+			return nil, nil, fmt.Errorf("input 'value' is required")
+		}
+		message, _ := inputs["message"].(string)
+
+		decision, err := scope.run.awaitApproval(ctx, scope, value, message)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !decision.Approved {
+			if decision.Comment != "" {
+				return nil, nil, fmt.Errorf("%w: %s", ErrRejected, decision.Comment)
+			}
+			return nil, nil, ErrRejected
+		}
+		if decision.Value != nil {
+			return decision.Value, nil, nil
+		}
+		return value, nil, nil
+	},
+	// Dry runs do not wait for decisions and approve the value.
+	Placeholder: func(inputs map[string]interface{}) interface{} {
+		return inputs["value"]
+	},
+}
+
+// pendingApproval is an approval a task is waiting for in this process.
+type pendingApproval struct {
+	Approval
+	decided chan Decision
+}
+
+func approvalKey(runID, taskID string) string {
+	return runID + "/" + taskID
+}
+
+// awaitApproval waits for the decision on the value of a task. Approvals of
+// tasks in sub-workflows are checkpointed under their path in the top-level
+// run. A decision recorded in the store for the run being resumed is used
+// right away. Otherwise the task is registered as waiting before the
+// checkpoint marks it as waiting, so no decision is lost in between, and the
+// decision can also be made while the run is not executing.
+func (r *Run) awaitApproval(ctx context.Context, scope runTask, value interface{}, message string) (Decision, error) {
+	runID, taskID := r.path(scope.taskID)
+	cp := r.root().checkpoint
+	decided := func(decision Decision) (Decision, error) {
+		// A sub-workflow runs again as a whole, so its approvals are asked
+		// for again rather than resumed.
+		if r.parent != nil {
+			if err := cp.forget(taskID); err != nil {
+				return Decision{}, err
+			}
+		}
+		return decision, nil
+	}
+	if decision, ok := cp.decision(taskID); ok {
+		return decided(decision)
+	}
+
+	approval := Approval{RunID: runID, TaskID: taskID, Message: message, Value: value, RequestedAt: time.Now()}
+	pending := &pendingApproval{Approval: approval, decided: make(chan Decision, 1)}
+	key := approvalKey(runID, taskID)
+	m := r.manager
+	m.mu.Lock()
+	if _, ok := m.approvals[key]; ok {
+		m.mu.Unlock()
+		return Decision{}, fmt.Errorf("task %s is already waiting for an approval", taskID)
+	}
+	if m.approvals == nil {
+		m.approvals = make(map[string]*pendingApproval)
+	}
+	m.approvals[key] = pending
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		if m.approvals[key] == pending {
+			delete(m.approvals, key)
+		}
+		m.mu.Unlock()
+	}()
+	if err := cp.awaitApproval(taskID, approval); err != nil {
+		return Decision{}, err
+	}
+
+	r.publish(Event{Type: ApprovalRequested, AgentID: scope.agentID, TaskID: scope.taskID})
+	select {
+	case decision := <-pending.decided:
+		return decided(decision)
+	case <-ctx.Done():
+		return Decision{}, context.Cause(ctx)
+	}
+}
+
+// root returns the top-level run of r.
+func (r *Run) root() *Run {
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
+
+// path returns the ID of the top-level run of r and the path of its task
+// taskID in it.
+func (r *Run) path(taskID string) (string, string) {
+	for r.parent != nil {
+		taskID = r.parentTask + "/" + taskID
+		r = r.parent
+	}
+	return r.ID, taskID
+}
+
+// Approvals returns the approvals that tasks of this manager's runs are
+// waiting for, oldest first.
+func (m *Manager) Approvals() []Approval {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	approvals := make([]Approval, 0, len(m.approvals))
+	for _, pending := range m.approvals {
+		approvals = append(approvals, pending.Approval)
+	}
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].RequestedAt.Before(approvals[j].RequestedAt)
+	})
+	return approvals
+}
+
+// Decide answers the approval task taskID of run runID is waiting for. When
+// no task of this process waits for it, the decision is recorded in Store
+// for a run that stopped while the task was waiting; resuming the run then
+// applies it. ErrRunExecuting is returned when the stored run has not
+// stopped, so the decision must be made in the process executing it, and
+// ErrApprovalNotFound when there is nothing to decide.
+func (m *Manager) Decide(runID, taskID string, decision Decision) error {
+	m.mu.Lock()
+	pending, ok := m.approvals[approvalKey(runID, taskID)]
+	if ok {
+		delete(m.approvals, approvalKey(runID, taskID))
+	}
+	store := m.Store
+	m.mu.Unlock()
+	if ok {
+		pending.decided <- decision
+		return nil
+	}
+
+	if store == nil {
+		return fmt.Errorf("%w for task %s of run %s", ErrApprovalNotFound, taskID, runID)
+	}
+	state, err := store.Load(runID)
+	if errors.Is(err, ErrRunNotFound) {
+		return fmt.Errorf("%w for task %s of run %s", ErrApprovalNotFound, taskID, runID)
+	}
+	if err != nil {
+		return err
+	}
+	if state.Status == StatusRunning {
+		return fmt.Errorf("%w: decide on task %s of run %s in the process executing it", ErrRunExecuting, taskID, runID)
+	}
+	task, ok := state.Tasks[taskID]
+	if !ok || task.Status != StatusWaiting {
+		return fmt.Errorf("%w for task %s of run %s", ErrApprovalNotFound, taskID, runID)
+	}
+	task.Decision = &decision
+	task.UpdatedAt = time.Now()
+	state.UpdatedAt = task.UpdatedAt
+	return store.Save(state)
+}
@@ -0,0 +1,246 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// approvalConfig drafts a value, waits for its approval and publishes the
+// approved value.
+var approvalConfig = WorkflowConfig{
+	Tasks: []TaskConfig{
+		{ID: "draft", ToolID: "draft"},
+		{ID: "approve", ToolID: ApprovalTool.ID, InputsFrom: map[string]string{"value": "draft"}, Inputs: map[string]interface{}{"message": "check it"}},
+		{ID: "publish", ToolID: "echo", InputsFrom: map[string]string{"value": "approve"}},
+	},
+	Agents: []AgentConfig{
+		{ID: "writer", Tasks: []string{"draft"}},
+		{ID: "review", DependsOn: []string{"writer"}, Tasks: []string{"approve", "publish"}},
+	},
+}
+
+// approvalManager returns a manager of approvalConfig and a pointer to the
+// number of drafts made.
+func approvalManager(t *testing.T) (*Manager, *int) {
+	drafts := 0
+	draft := funcTool("draft", func(inputs map[string]interface{}) (interface{}, error) {
+		drafts++
+		return "first draft", nil
+	})
+	return newTestManager(t, approvalConfig, draft, echoTool), &drafts
+}
+
+// onApproval calls decide for every approval the manager's runs request.
+func onApproval(m *Manager, decide func(approval Approval)) {
+	m.Events.OnEvent(func(event Event) {
+		if event.Type != ApprovalRequested {
+			return
+		}
+		for _, approval := range m.Approvals() {
+			if approval.RunID == event.RunID {
+				decide(approval)
+			}
+		}
+	})
+}
+
+func TestApproveInProcess(t *testing.T) {
+	m, _ := approvalManager(t)
+	var requested []Approval
+	onApproval(m, func(approval Approval) {
+		requested = append(requested, approval)
+		if err := m.Decide(approval.RunID, approval.TaskID, Decision{Approved: true, Value: "edited draft"}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 1 || requested[0].TaskID != "approve" || requested[0].Value != "first draft" || requested[0].Message != "check it" {
+		t.Errorf("approvals = %+v", requested)
+	}
+	if result := run.Tasks["publish"].Result; result != "edited draft" {
+		t.Errorf("published %v, want the edited value", result)
+	}
+	if approvals := m.Approvals(); len(approvals) != 0 {
+		t.Errorf("approvals still pending: %+v", approvals)
+	}
+}
+
+func TestRejectFailsTask(t *testing.T) {
+	m, _ := approvalManager(t)
+	onApproval(m, func(approval Approval) {
+		m.Decide(approval.RunID, approval.TaskID, Decision{Comment: "too long"})
+	})
+
+	run, _ := m.NewRun()
+	err := run.Execute(context.Background())
+	if !errors.Is(err, ErrRejected) || err.Error() != "rejected: too long" {
+		t.Errorf("Execute() = %v, want the rejection with its comment", err)
+	}
+	if run.Tasks["publish"].Result != nil {
+		t.Error("a rejected value was published")
+	}
+}
+
+func TestRejectionIsNotRetried(t *testing.T) {
+	config := approvalConfig
+	config.Tasks = append([]TaskConfig(nil), approvalConfig.Tasks...)
+	config.Tasks[1].Retries = 2
+	draft := funcTool("draft", func(inputs map[string]interface{}) (interface{}, error) {
+		return "first draft", nil
+	})
+	m := newTestManager(t, config, draft, echoTool)
+	requests := 0
+	onApproval(m, func(approval Approval) {
+		requests++
+		m.Decide(approval.RunID, approval.TaskID, Decision{})
+	})
+	recorder := recordEvents(m)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); !errors.Is(err, ErrRejected) {
+		t.Fatalf("Execute() = %v, want the rejection", err)
+	}
+	if requests != 1 {
+		t.Errorf("approval was requested %d times, want once", requests)
+	}
+	for _, event := range recorder.events {
+		if event.Type == TaskRetried {
+			t.Errorf("task %s was retried after the rejection", event.TaskID)
+		}
+	}
+}
+
+func TestDecideStoredRun(t *testing.T) {
+	m, drafts := approvalManager(t)
+	store := NewMemoryStore()
+	m.Store = store
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runID string
+	onApproval(m, func(approval Approval) {
+		runID = approval.RunID
+		// Another process sharing the store cannot decide while the run
+		// executes here.
+		other := NewManager()
+		other.Store = store
+		if err := other.Decide(approval.RunID, approval.TaskID, Decision{Approved: true}); !errors.Is(err, ErrRunExecuting) {
+			t.Errorf("Decide in another process = %v, want ErrRunExecuting", err)
+		}
+		cancel()
+	})
+
+	run, _ := m.NewRun()
+	if err := run.Execute(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Execute() = %v, want it stopped while waiting", err)
+	}
+	state, _ := store.Load(runID)
+	if task := state.Tasks["approve"]; task.Status != StatusWaiting || task.Approval == nil || task.Approval.Value != "first draft" {
+		t.Fatalf("checkpoint of the approval = %+v", task)
+	}
+
+	other := NewManager()
+	other.Store = store
+	if err := other.Decide(runID, "publish", Decision{Approved: true}); !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("Decide on a task that is not waiting = %v, want ErrApprovalNotFound", err)
+	}
+	if err := other.Decide(runID, "approve", Decision{Approved: true, Value: "stored decision"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Resume(runID); err != nil {
+		t.Fatal(err)
+	}
+	if *drafts != 1 {
+		t.Errorf("drafted %d times, want the draft restored", *drafts)
+	}
+	if result := m.Tasks["publish"].Result; result != "stored decision" {
+		t.Errorf("published %v, want the stored decision's value", result)
+	}
+}
+
+func TestDecideWithoutApproval(t *testing.T) {
+	m, _ := approvalManager(t)
+	if err := m.Decide("run", "approve", Decision{Approved: true}); !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("Decide without a store = %v, want ErrApprovalNotFound", err)
+	}
+	m.Store = NewMemoryStore()
+	if err := m.Decide("run", "approve", Decision{Approved: true}); !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("Decide on an unknown run = %v, want ErrApprovalNotFound", err)
+	}
+	if _, _, err := ApprovalTool.ExecuteContext(context.Background(), map[string]interface{}{"value": 1}); err == nil {
+		t.Error("an approval outside a run succeeded")
+	}
+}
+
+func TestNestedApproval(t *testing.T) {
+	m, drafts := approvalManager(t)
+	if err := m.RegisterWorkflow("review", "Review", WorkflowConfig{
+		Tasks:   []TaskConfig{{ID: "approve", ToolID: ApprovalTool.ID}},
+		Agents:  []AgentConfig{{ID: "reviewer", Tasks: []string{"approve"}}},
+		Inputs:  []WorkflowInput{{Name: "value", To: []string{"approve.value"}}},
+		Outputs: map[string]string{"value": "approve"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InitializeWorkflow(WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "check", ToolID: "review", InputsFrom: map[string]string{"value": "draft"}}},
+		Agents: []AgentConfig{{ID: "checker", DependsOn: []string{"writer"}, Tasks: []string{"check"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	m.Store = store
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var paths []string
+	onApproval(m, func(approval Approval) {
+		paths = append(paths, approval.TaskID)
+		if approval.TaskID == "check/approve" {
+			cancel()
+		} else {
+			m.Decide(approval.RunID, approval.TaskID, Decision{Approved: true})
+		}
+	})
+
+	run, _ := m.NewRun("checker")
+	if err := run.Execute(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Execute() = %v, want it stopped while waiting", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"check/approve"}) {
+		t.Errorf("approvals requested for %v, want the path of the nested task", paths)
+	}
+	state, _ := store.Load(run.ID)
+	if task := state.Tasks["check/approve"]; task == nil || task.Status != StatusWaiting {
+		t.Fatalf("nested approval checkpoint = %+v", state.Tasks)
+	}
+
+	other := NewManager()
+	other.Store = store
+	if err := other.Decide(run.ID, "check/approve", Decision{Approved: true, Value: "nested decision"}); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := m.ResumeRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"value": "nested decision"}
+	if result := resumed.Tasks["check"].Result; !reflect.DeepEqual(result, want) {
+		t.Errorf("check = %#v, want %#v", result, want)
+	}
+	if *drafts != 1 {
+		t.Errorf("drafted %d times, want the draft restored", *drafts)
+	}
+	state, _ = store.Load(run.ID)
+	if _, ok := state.Tasks["check/approve"]; ok {
+		t.Error("the decided nested approval is still in the checkpoint")
+	}
+}
@@ -0,0 +1,223 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+type TranscriptionSegment struct {
+	ID    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type TranscriptionWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Transcription is the output of TranscriptionTool. For the text, srt and
+// vtt formats Text holds the raw response body.
+type Transcription struct {
+	Text     string                 `json:"text"`
+	Format   string                 `json:"format"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"`
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+	Words    []TranscriptionWord    `json:"words,omitempty"`
+	Path     string                 `json:"path,omitempty"`
+}
+
+type SpeechResult struct {
+	Path        string `json:"path"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+var (
+	TranscriptionTool = &Tool{
+		ID:   "audio_transcription",
+		Name: "Audio Transcription",
+		Inputs: append([]ToolInput{
+			{Name: "file", Type: "string", Required: true, Description: "audio file path"},
+			{Name: "model", Type: "string", Description: "transcription model, default whisper-1"},
+			{Name: "language", Type: "string", Description: "language of the audio"},
+			{Name: "prompt", Type: "string", Description: "text to guide the transcription"},
+			{Name: "temperature", Type: "float", Description: "sampling temperature"},
+			{Name: "response_format", Type: "string", Description: "json, verbose_json, text, srt or vtt"},
+			{Name: "timestamp_granularities", Type: "[]string", Description: "word and/or segment"},
+			{Name: "output_path", Type: "string", Description: "file to write the transcription to"},
+		}, clientInputs...),
+		Estimate: estimateTranscription,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			audioPath, ok := inputs["file"].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("input 'file' is required and must be a string")
+			}
+
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+
+			data, err := os.ReadFile(audioPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read audio file: %v", err)
+			}
+
+			format := "json"
+			if f, ok := inputs["response_format"].(string); ok && f != "" {
+				format = f
+			}
+
+			fields := url.Values{}
+			fields.Set("model", "whisper-1")
+			fields.Set("response_format", format)
+			for _, key := range []string{"model", "language", "prompt"} {
+				if v, ok := inputs[key].(string); ok && v != "" {
+					fields.Set(key, v)
+				}
+			}
+			if temperature, ok := floatInput(inputs, "temperature"); ok {
+				fields.Set("temperature", strconv.FormatFloat(temperature, 'f', -1, 64))
+			}
+			granularities, err := stringsInput(inputs, "timestamp_granularities")
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, granularity := range granularities {
+				fields.Add("timestamp_granularities[]", granularity)
+			}
+
+			file := multipartFile{
+				Field:       "file",
+				Name:        filepath.Base(audioPath),
+				ContentType: mimetype.Detect(data).String(),
+				Data:        data,
+			}
+
+			if verbose {
+				log.Printf("Transcribing %s (%d bytes) as %s", audioPath, len(data), format)
+			}
+
+			body, err := client.postMultipartRaw(ctx, "/audio/transcriptions", fields, []multipartFile{file})
+			if err != nil {
+				return nil, nil, err
+			}
+
+			transcription := Transcription{Format: format}
+			switch format {
+			case "json", "verbose_json":
+				if err := json.Unmarshal(body, &transcription); err != nil {
+					return nil, nil, fmt.Errorf("failed to decode response: %v", err)
+				}
+				transcription.Format = format
+			default:
+				transcription.Text = string(body)
+			}
+
+			if outputPath, ok := inputs["output_path"].(string); ok && outputPath != "" {
+				if err := os.WriteFile(outputPath, []byte(transcription.Text), 0o644); err != nil {
+					return nil, nil, fmt.Errorf("failed to write transcription: %v", err)
+				}
+				transcription.Path = outputPath
+			}
+
+			return transcription, nil, nil
+		},
+	}
+
+	SpeechTool = &Tool{
+		ID:   "text_to_speech",
+		Name: "Text to Speech",
+		Inputs: append([]ToolInput{
+			{Name: "text", Type: "string", Required: true, Description: "text to speak"},
+			{Name: "model", Type: "string", Description: "speech model, default tts-1"},
+			{Name: "voice", Type: "string", Description: "voice, default alloy"},
+			{Name: "response_format", Type: "string", Description: "audio format, default mp3"},
+			{Name: "instructions", Type: "string", Description: "how to speak"},
+			{Name: "speed", Type: "float", Description: "speaking speed"},
+			{Name: "output_path", Type: "string", Description: "file to write the audio to"},
+			{Name: "output_dir", Type: "string", Description: "directory for a new audio file"},
+		}, clientInputs...),
+		Estimate: estimateSpeech,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			text, ok := inputs["text"].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("input 'text' is required and must be a string")
+			}
+
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+
+			data := map[string]interface{}{
+				"model":           "tts-1",
+				"voice":           "alloy",
+				"response_format": "mp3",
+				"input":           text,
+			}
+			for _, key := range []string{"model", "voice", "response_format", "instructions"} {
+				if v, ok := inputs[key].(string); ok && v != "" {
+					data[key] = v
+				}
+			}
+			if speed, ok := floatInput(inputs, "speed"); ok {
+				data["speed"] = speed
+			}
+
+			if verbose {
+				log.Printf("Synthesizing %d characters of speech with voice %s", len(text), data["voice"])
+			}
+
+			audio, err := client.postJSONRaw(ctx, "/audio/speech", data)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			outputPath, _ := inputs["output_path"].(string)
+			if outputPath == "" {
+				dir, _ := inputs["output_dir"].(string)
+				if dir != "" {
+					if err := os.MkdirAll(dir, 0o755); err != nil {
+						return nil, nil, fmt.Errorf("failed to create output directory: %v", err)
+					}
+				}
+				f, err := os.CreateTemp(dir, "speech-*."+data["response_format"].(string))
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to create audio file: %v", err)
+				}
+				f.Close()
+				outputPath = f.Name()
+			}
+			if err := os.WriteFile(outputPath, audio, 0o644); err != nil {
+				return nil, nil, fmt.Errorf("failed to write audio file: %v", err)
+			}
+
+			if verbose {
+				log.Printf("Wrote %d bytes of audio to %s", len(audio), outputPath)
+			}
+
+			return SpeechResult{
+				Path:        outputPath,
+				ContentType: mimetype.Detect(audio).String(),
+				Size:        int64(len(audio)),
+			}, nil, nil
+		},
+	}
+)
@@ -0,0 +1,119 @@
+package aicraft
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAudio writes a small audio file and returns its path.
+func testAudio(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "meeting.mp3")
+	if err := os.WriteFile(path, []byte("ID3\x03\x00\x00\x00\x00\x00\x00 not really audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTranscriptionVerboseJSON(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/audio/transcriptions": func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Fatal(err)
+			}
+			form := r.MultipartForm.Value
+			if form["model"][0] != "whisper-1" || form["response_format"][0] != "verbose_json" || form["language"][0] != "de" || form["temperature"][0] != "0.2" {
+				t.Errorf("form = %v", form)
+			}
+			if got := form["timestamp_granularities[]"]; !reflect.DeepEqual(got, []string{"word", "segment"}) {
+				t.Errorf("timestamp_granularities[] = %v", got)
+			}
+			if _, header, err := r.FormFile("file"); err != nil || header.Filename != "meeting.mp3" {
+				t.Errorf("file part: %v", err)
+			}
+			io.WriteString(w, `{"text": "Hallo zusammen", "language": "german", "duration": 1.5,
+				"segments": [{"id": 0, "start": 0, "end": 1.5, "text": "Hallo zusammen"}],
+				"words": [{"word": "Hallo", "start": 0, "end": 0.5}]}`)
+		},
+	})
+
+	result, _, err := TranscriptionTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"file":                    testAudio(t),
+		"language":                "de",
+		"temperature":             0.2,
+		"response_format":         "verbose_json",
+		"timestamp_granularities": []interface{}{"word", "segment"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcription := result.(Transcription)
+	if transcription.Text != "Hallo zusammen" || transcription.Format != "verbose_json" || transcription.Duration != 1.5 ||
+		len(transcription.Segments) != 1 || len(transcription.Words) != 1 {
+		t.Errorf("transcription = %+v", transcription)
+	}
+}
+
+func TestTranscriptionSubtitles(t *testing.T) {
+	const srt = "1\n00:00:00,000 --> 00:00:01,500\nHallo zusammen\n"
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/audio/transcriptions": func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, srt)
+		},
+	})
+	output := filepath.Join(t.TempDir(), "meeting.srt")
+
+	result, _, err := TranscriptionTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"file":            testAudio(t),
+		"response_format": "srt",
+		"output_path":     output,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcription := result.(Transcription); transcription.Text != srt || transcription.Path != output {
+		t.Errorf("transcription = %+v", transcription)
+	}
+	if written, _ := os.ReadFile(output); string(written) != srt {
+		t.Errorf("output file = %q", written)
+	}
+}
+
+func TestSpeechWritesAudio(t *testing.T) {
+	audio := []byte("ID3\x03\x00\x00\x00\x00\x00\x00 speech")
+	var request map[string]interface{}
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/audio/speech": func(w http.ResponseWriter, r *http.Request) {
+			request = decodeTestJSON(t, r)
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write(audio)
+		},
+	})
+	dir := filepath.Join(t.TempDir(), "speech")
+
+	result, _, err := SpeechTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"text":       "Welcome",
+		"voice":      "nova",
+		"speed":      1.25,
+		"output_dir": dir,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request["input"] != "Welcome" || request["voice"] != "nova" || request["model"] != "tts-1" || request["speed"] != 1.25 {
+		t.Errorf("request = %v", request)
+	}
+	speech := result.(SpeechResult)
+	if filepath.Dir(speech.Path) != dir || !strings.HasSuffix(speech.Path, ".mp3") || speech.Size != int64(len(audio)) || speech.ContentType != "audio/mpeg" {
+		t.Errorf("speech = %+v", speech)
+	}
+	if written, _ := os.ReadFile(speech.Path); string(written) != string(audio) {
+		t.Errorf("audio file = %q", written)
+	}
+}
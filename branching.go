@@ -0,0 +1,209 @@
+package aicraft
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// route is one route of a RouterTool task.
+type route struct {
+	If string `json:"if"`
+	To string `json:"to"`
+}
+
+// RouterTool picks a branch of the workflow. Its result is the 'to' of the
+// first route whose 'if' expression holds for the task's other inputs,
+// typically filled with InputsFrom, or 'default'. Agents select a branch with
+// a condition such as route == "images".
+var RouterTool = &Tool{
+	ID:   "router",
+	Name: "Router",
+	Inputs: []ToolInput{
+		{Name: "routes", Type: "list", Required: true, Description: "routes tried in order, each with an 'if' expression over the other inputs and the branch name 'to'"},
+		{Name: "default", Type: "string", Description: "branch taken when no route matches"},
+	},
+	Estimate: noUsage,
+	ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		routes, err := routerRoutes(inputs["routes"])
+		if err != nil {
+			return nil, nil, err
+		}
+		vars := make(map[string]interface{}, len(inputs))
+		for name, value := range inputs {
+			if name != "routes" && name != "default" {
+				vars[name] = value
+			}
+		}
+		for _, route := range routes {
+			condition, err := parseExpression(route.If)
+			if err != nil {
+				return nil, nil, err
+			}
+			ok, err := condition.evaluate(vars)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				return route.To, nil, nil
+			}
+		}
+		branch, _ := inputs["default"].(string)
+		return branch, nil, nil
+	},
+	// In a dry run the inputs the routes test are placeholders, so the
+	// router takes its default branch, or else its first route.
+	Placeholder: func(inputs map[string]interface{}) interface{} {
+		if branch, _ := inputs["default"].(string); branch != "" {
+			return branch
+		}
+		if routes, err := routerRoutes(inputs["routes"]); err == nil && len(routes) > 0 {
+			return routes[0].To
+		}
+		return ""
+	},
+}
+
+func routerRoutes(input interface{}) ([]route, error) {
+	normalized, err := normalize(input)
+	list, ok := normalized.([]interface{})
+	if err != nil || !ok {
+		return nil, fmt.Errorf("input 'routes' is required and must be a list")
+	}
+	routes := make([]route, len(list))
+	for i, item := range list {
+		object, _ := item.(map[string]interface{})
+		routes[i].If, _ = object["if"].(string)
+		routes[i].To, _ = object["to"].(string)
+		if routes[i].If == "" || routes[i].To == "" {
+			return nil, fmt.Errorf("route %d needs 'if' and 'to'", i+1)
+		}
+	}
+	return routes, nil
+}
+
+// checkCondition checks that the condition of an agent parses and only
+// refers to tasks for which task returns true.
+func checkCondition(agentID, condition string, task func(id string) bool) error {
+	if condition == "" {
+		return nil
+	}
+	expr, err := parseExpression(condition)
+	if err != nil {
+		return fmt.Errorf("agent %s: %v", agentID, err)
+	}
+	for _, name := range expr.names() {
+		if !task(name) {
+			return fmt.Errorf("agent %s has a condition on %w %s", agentID, ErrTaskNotFound, name)
+		}
+	}
+	return nil
+}
+
+// checkExpressionScope checks that the condition of agent only refers to
+// tasks of the agents it depends on, directly or not, and its Until
+// expression also to its own tasks: the results of other tasks may or may
+// not be there when the expressions are evaluated.
+func checkExpressionScope(agent AgentConfig, dependsOn, agentTasks map[string][]string) error {
+	visible := make(map[string]bool)
+	seen := make(map[string]bool)
+	var see func(id string)
+	see = func(id string) {
+		for _, dep := range dependsOn[id] {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			for _, taskID := range agentTasks[dep] {
+				visible[taskID] = true
+			}
+			see(dep)
+		}
+	}
+	see(agent.ID)
+
+	check := func(kind, expression string) error {
+		if expression == "" {
+			return nil
+		}
+		expr, err := parseExpression(expression)
+		if err != nil {
+			return fmt.Errorf("agent %s: %v", agent.ID, err)
+		}
+		for _, name := range expr.names() {
+			if !visible[name] {
+				return fmt.Errorf("agent %s has %s on task %s, which no agent it depends on runs", agent.ID, kind, name)
+			}
+		}
+		return nil
+	}
+	if err := check("a condition", agent.Condition); err != nil {
+		return err
+	}
+	for _, taskID := range agent.Tasks {
+		visible[taskID] = true
+	}
+	return check("an until expression", agent.Until)
+}
+
+// skip reports whether agent is skipped: when all its dependencies were
+// skipped, or when its condition does not hold for the results of the
+// agents that have finished.
+func (r *Run) skip(agent *Agent) (bool, error) {
+	if len(agent.DependsOn) > 0 {
+		skipped := true
+		for _, dep := range agent.DependsOn {
+			skipped = skipped && r.Agents[dep].Skipped
+		}
+		if skipped {
+			return true, nil
+		}
+	}
+	if agent.Condition == "" {
+		return false, nil
+	}
+
+	condition, err := parseExpression(agent.Condition)
+	if err != nil {
+		return false, err
+	}
+	ok, err := condition.evaluate(r.taskResults(nil))
+	return !ok, err
+}
+
+// taskResults returns the results of the tasks of the agents that have
+// finished and of agent, if not nil, by task ID.
+func (r *Run) taskResults(agent *Agent) map[string]interface{} {
+	vars := make(map[string]interface{})
+	r.mu.Lock()
+	for id := range r.finished {
+		for _, task := range r.Agents[id].Tasks {
+			vars[task.ID] = task.Result
+		}
+	}
+	r.mu.Unlock()
+	if agent != nil {
+		for _, task := range agent.Tasks {
+			vars[task.ID] = task.Result
+		}
+	}
+	return vars
+}
+
+// skipAgent marks agent as skipped.
+func (r *Run) skipAgent(agent *Agent) {
+	agent.Skipped = true
+	r.publish(Event{Type: AgentSkipped, AgentID: agent.ID})
+}
+
+// Skipped returns the IDs of the agents the run skipped.
+func (r *Run) Skipped() []string {
+	var skipped []string
+	for id, agent := range r.Agents {
+		if agent.Skipped {
+			skipped = append(skipped, id)
+		}
+	}
+	sort.Strings(skipped)
+	return skipped
+}
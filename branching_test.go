@@ -0,0 +1,110 @@
+package aicraft
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// routedConfig routes the result of task "classify" to agent "long" or
+// "short", and runs agent "after", which depends on both, unless both were
+// skipped.
+func routedConfig(text string) WorkflowConfig {
+	return WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "classify", ToolID: "echo", Inputs: map[string]interface{}{"value": text}},
+			{ID: "route", ToolID: RouterTool.ID, InputsFrom: map[string]string{"text": "classify"}, Inputs: map[string]interface{}{
+				"routes":  []interface{}{map[string]interface{}{"if": "len(text) > 10", "to": "long"}},
+				"default": "short",
+			}},
+			{ID: "summarize", ToolID: "echo", Inputs: map[string]interface{}{"value": "summary"}},
+			{ID: "keep", ToolID: "echo", Inputs: map[string]interface{}{"value": "kept"}},
+			{ID: "finish", ToolID: "echo", Inputs: map[string]interface{}{"value": "finished"}},
+		},
+		Agents: []AgentConfig{
+			{ID: "classifier", Tasks: []string{"classify", "route"}},
+			{ID: "long", DependsOn: []string{"classifier"}, Condition: `route == "long"`, Tasks: []string{"summarize"}},
+			{ID: "short", DependsOn: []string{"classifier"}, Condition: `route == "short" && classify != ""`, Tasks: []string{"keep"}},
+			{ID: "after", DependsOn: []string{"long"}, Tasks: []string{"finish"}},
+		},
+	}
+}
+
+func TestRouterPicksBranch(t *testing.T) {
+	tests := []struct {
+		text    string
+		skipped []string
+		results []string
+	}{
+		{"a rather long text", []string{"short"}, []string{"classify", "finish", "route", "summarize"}},
+		{"brief", []string{"after", "long"}, []string{"classify", "keep", "route"}},
+	}
+	for _, test := range tests {
+		m := newTestManager(t, routedConfig(test.text), echoTool)
+		recorder := recordEvents(m)
+		run, _ := m.NewRun()
+		if err := run.Execute(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if skipped := run.Skipped(); !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("%q: skipped %v, want %v", test.text, skipped, test.skipped)
+		}
+		var results []string
+		for id := range run.Results() {
+			results = append(results, id)
+		}
+		sort.Strings(results)
+		if !reflect.DeepEqual(results, test.results) {
+			t.Errorf("%q: results of %v, want %v", test.text, results, test.results)
+		}
+		skippedEvents := 0
+		for _, eventType := range recorder.types("") {
+			if eventType == AgentSkipped {
+				skippedEvents++
+			}
+		}
+		if skippedEvents != len(test.skipped) {
+			t.Errorf("%q: %d AgentSkipped events, want %d", test.text, skippedEvents, len(test.skipped))
+		}
+	}
+}
+
+func TestRouterInputErrors(t *testing.T) {
+	for name, inputs := range map[string]map[string]interface{}{
+		"no routes":        {},
+		"incomplete route": {"routes": []interface{}{map[string]interface{}{"if": "true"}}},
+		"invalid if":       {"routes": []interface{}{map[string]interface{}{"if": "a ==", "to": "x"}}},
+	} {
+		if _, _, err := RouterTool.ExecuteContext(context.Background(), inputs); err == nil {
+			t.Errorf("%s: Execute succeeded", name)
+		}
+	}
+}
+
+func TestConditionValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent AgentConfig
+		want  string
+	}{
+		{"unknown task", AgentConfig{ID: "b", DependsOn: []string{"a"}, Condition: "missing"}, "condition on unknown task missing"},
+		{"invalid", AgentConfig{ID: "b", DependsOn: []string{"a"}, Condition: "x =="}, "invalid expression"},
+		{"outside scope", AgentConfig{ID: "b", Condition: "x"}, "a condition on task x, which no agent it depends on runs"},
+		{"own task", AgentConfig{ID: "b", DependsOn: []string{"a"}, Condition: "y", Tasks: []string{"y"}}, "a condition on task y"},
+		{"until outside scope", AgentConfig{ID: "b", Until: "z", MaxIterations: 2, Tasks: []string{"y"}}, "an until expression on task z"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManager(t, WorkflowConfig{}, echoTool)
+			err := m.InitializeWorkflow(WorkflowConfig{
+				Tasks:  []TaskConfig{{ID: "x", ToolID: "echo"}, {ID: "y", ToolID: "echo"}, {ID: "z", ToolID: "echo"}},
+				Agents: []AgentConfig{{ID: "a", Tasks: []string{"x"}}, test.agent, {ID: "c", Tasks: []string{"z"}}},
+			})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("InitializeWorkflow() = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
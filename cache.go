@@ -0,0 +1,211 @@
+package aicraft
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores provider responses. The client caches embedding requests and
+// chat completions with a temperature of 0, keyed by the provider, model,
+// input and parameters of the request.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Stats() CacheStats
+}
+
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+type cacheCounters struct {
+	hits   int64
+	misses int64
+}
+
+func (c *cacheCounters) count(hit bool) {
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounters) Stats() CacheStats {
+	return CacheStats{Hits: atomic.LoadInt64(&c.hits), Misses: atomic.LoadInt64(&c.misses)}
+}
+
+// MemoryCache is an in-memory least recently used cache.
+type MemoryCache struct {
+	cacheCounters
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns a cache holding at most capacity entries, or any
+// number when capacity is 0. Entries expire after ttl unless it is 0.
+func NewMemoryCache(capacity int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*memoryEntry)
+		if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+			c.order.Remove(element)
+			delete(c.entries, key)
+			ok = false
+		} else {
+			c.order.MoveToFront(element)
+		}
+	}
+	c.count(ok)
+	if !ok {
+		return nil, false
+	}
+	return element.Value.(*memoryEntry).value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &memoryEntry{key: key, value: value}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	if c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// DiskCache stores one file per entry in a directory, so cached responses
+// survive between processes.
+type DiskCache struct {
+	cacheCounters
+	dir string
+	ttl time.Duration
+}
+
+// NewDiskCache returns a cache in dir, creating it if needed. Entries
+// expire after ttl unless it is 0.
+func NewDiskCache(dir string, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &DiskCache{dir: dir, ttl: ttl}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err == nil && c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		os.Remove(path)
+		err = os.ErrNotExist
+	}
+	var value []byte
+	if err == nil {
+		value, err = os.ReadFile(path)
+	}
+	c.count(err == nil)
+	return value, err == nil
+}
+
+func (c *DiskCache) Set(key string, value []byte) {
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+type bypassCacheKey struct{}
+
+// withoutCache marks requests made with ctx as not to be cached.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+// cacheKey returns the key of a request to path with data, or "" when the
+// request must not be cached.
+func (c *Client) cacheKey(ctx context.Context, path string, data map[string]interface{}) string {
+	if c.Cache == nil {
+		return ""
+	}
+	if bypass, _ := ctx.Value(bypassCacheKey{}).(bool); bypass {
+		return ""
+	}
+	switch path {
+	case "/embeddings":
+	case "/chat/completions":
+		if temperature, ok := floatInput(data, "temperature"); !ok || temperature != 0 {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	// encoding/json sorts map keys, so equal requests encode equally.
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	// Responses are kept apart per API key, so that clients with different
+	// keys, such as the tenants of a server, do not see each other's.
+	apiKey := sha256.Sum256([]byte(c.APIKey))
+	hash := sha256.New()
+	hash.Write([]byte(c.BaseURL + path + "\n"))
+	hash.Write(apiKey[:])
+	hash.Write(encoded)
+	return hex.EncodeToString(hash.Sum(nil))
+}
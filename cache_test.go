@@ -0,0 +1,194 @@
+package aicraft
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewMemoryCache(2, 0)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+	c.Set("a", []byte("updated"))
+	if value, _ := c.Get("a"); string(value) != "updated" {
+		t.Errorf("Get(a) = %q after an update", value)
+	}
+	if stats := c.Stats(); stats.Hits != 4 || stats.Misses != 1 {
+		t.Errorf("Stats() = %+v, want 4 hits and 1 miss", stats)
+	}
+}
+
+func TestMemoryCacheExpiresEntries(t *testing.T) {
+	c := NewMemoryCache(0, 20*time.Millisecond)
+	c.Set("a", []byte("1"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a fresh entry is missing")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("an expired entry was returned")
+	}
+	if len(c.entries) != 0 || c.order.Len() != 0 {
+		t.Error("the expired entry was not removed")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c, err := NewDiskCache(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", []byte("1"))
+	if value, ok := c.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v", value, ok)
+	}
+
+	// Entries are read back by a cache in another process.
+	reopened, _ := NewDiskCache(dir, time.Hour)
+	if _, ok := reopened.Get("a"); !ok {
+		t.Error("a new cache on the same directory misses the entry")
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path("a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("an expired entry was returned")
+	}
+	if _, err := os.Stat(c.path("a")); !os.IsNotExist(err) {
+		t.Error("the expired entry's file was not removed")
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get of a missing key succeeded")
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Stats() = %+v, want 1 hit and 2 misses", stats)
+	}
+}
+
+// countingProvider serves chat completions, streamed when requested, and
+// counts the requests it receives.
+func countingProvider(t *testing.T, requests *int) *Client {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			*requests++
+			if stream, _ := decodeTestJSON(t, r)["stream"].(bool); stream {
+				streamReply("cached ", "text")(w, r)
+				return
+			}
+			chatReply("cached text")(w, r)
+		},
+	})
+	client, err := clientFromInputs(context.Background(), providerInputs(srv, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Cache = NewMemoryCache(0, 0)
+	return client
+}
+
+func TestClientCachesDeterministicRequests(t *testing.T) {
+	requests := 0
+	client := countingProvider(t, &requests)
+	request := func(ctx context.Context, temperature float64) {
+		t.Helper()
+		text, err := client.chatCompletion(ctx, map[string]interface{}{"model": "gpt-4o", "temperature": temperature})
+		if err != nil || text != "cached text" {
+			t.Fatalf("chatCompletion() = %q, %v", text, err)
+		}
+	}
+
+	request(context.Background(), 0)
+	request(context.Background(), 0)
+	if requests != 1 {
+		t.Errorf("two temperature 0 requests reached the provider %d times, want 1", requests)
+	}
+	request(context.Background(), 0.7)
+	request(context.Background(), 0.7)
+	if requests != 3 {
+		t.Errorf("sampled requests were cached")
+	}
+	request(withoutCache(context.Background()), 0)
+	if requests != 4 {
+		t.Errorf("a request bypassing the cache was served from it")
+	}
+
+	other := *client
+	other.APIKey = "another-key"
+	if _, err := other.chatCompletion(context.Background(), map[string]interface{}{"model": "gpt-4o", "temperature": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 5 {
+		t.Errorf("a client with another API key was served the cached response")
+	}
+}
+
+func TestClientCachesFinishedStreams(t *testing.T) {
+	requests := 0
+	client := countingProvider(t, &requests)
+	stream := func() string {
+		t.Helper()
+		events, err := client.streamChat(context.Background(), map[string]interface{}{"model": "gpt-4o", "temperature": 0.0, "stream": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := readStream(events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return text
+	}
+
+	if text := stream(); text != "cached text" {
+		t.Errorf("first stream = %q", text)
+	}
+	if text := stream(); text != "cached text" {
+		t.Errorf("cached stream = %q", text)
+	}
+	if requests != 1 {
+		t.Errorf("the provider got %d requests, want 1", requests)
+	}
+}
+
+func TestClientDoesNotCacheTruncatedStreams(t *testing.T) {
+	requests := 0
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Content-Type", "text/event-stream")
+			writeSSE(w, map[string]interface{}{"choices": []map[string]interface{}{{"delta": map[string]string{"content": "partial"}}}})
+		},
+	})
+	client, _ := clientFromInputs(context.Background(), providerInputs(srv, nil))
+	client.Cache = NewMemoryCache(0, 0)
+
+	for i := 0; i < 2; i++ {
+		events, err := client.streamChat(context.Background(), map[string]interface{}{"model": "gpt-4o", "temperature": 0.0, "stream": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readStream(events); err == nil {
+			t.Fatal("want the truncated stream's error")
+		}
+	}
+	if requests != 2 {
+		t.Errorf("the provider got %d requests, want the truncated stream not to be cached", requests)
+	}
+}
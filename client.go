@@ -0,0 +1,405 @@
+package aicraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultBaseURL = "https://api.openai.com/v1"
+
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// Limiter, if set, queues requests to stay within rate limits. Share
+	// one limiter between clients to limit them together.
+	Limiter *RateLimiter
+	// Cache, if set, stores the responses of embedding requests and
+	// temperature 0 chat completions.
+	Cache Cache
+}
+
+// APIError is returned for non-2xx responses from the provider.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Param      string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("OpenAI API error (status %d, %s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("OpenAI API error (status %d): %s", e.StatusCode, e.Message)
+}
+
+// decodeAPIError builds an APIError from an error response body of the form
+// {"error": {"message": ..., "type": ..., "code": ..., "param": ...}}.
+func decodeAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	var payload struct {
+		Error *struct {
+			Message string      `json:"message"`
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"`
+			Param   interface{} `json:"param"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Error == nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(statusCode)
+		}
+		return apiErr
+	}
+	apiErr.Message = payload.Error.Message
+	apiErr.Type = payload.Error.Type
+	if payload.Error.Code != nil {
+		apiErr.Code = fmt.Sprint(payload.Error.Code)
+	}
+	if payload.Error.Param != nil {
+		apiErr.Param = fmt.Sprint(payload.Error.Param)
+	}
+	return apiErr
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func NewClient(apiKey string) *Client {
+	return &Client{
+		BaseURL:    defaultBaseURL,
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{},
+	}
+}
+
+type clientKey struct{}
+
+func withClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+type noBaseURLKey struct{}
+
+// ContextWithoutBaseURL makes the tools executed with ctx, including those
+// of for_each elements and sub-workflows, reject the 'base_url' input, so
+// that the API key of the Manager's client is only sent to its provider.
+// Servers running the workflows of their clients use it.
+func ContextWithoutBaseURL(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noBaseURLKey{}, true)
+}
+
+// clientFromInputs returns the client in ctx, or a new one, with the
+// common 'api_key' and 'base_url' task inputs applied. The returned client
+// shares the HTTP client and limiter of the one in ctx. 'api_key' is
+// required unless the client in ctx has a key.
+func clientFromInputs(ctx context.Context, inputs map[string]interface{}) (*Client, error) {
+	client := NewClient("")
+	if base, ok := ctx.Value(clientKey{}).(*Client); ok && base != nil {
+		copied := *base
+		client = &copied
+		if client.BaseURL == "" {
+			client.BaseURL = defaultBaseURL
+		}
+		if client.HTTPClient == nil {
+			client.HTTPClient = &http.Client{}
+		}
+	}
+	if apiKey, ok := inputs["api_key"].(string); ok {
+		client.APIKey = apiKey
+	} else if client.APIKey == "" {
+		return nil, fmt.Errorf("input 'api_key' is required and must be a string")
+	}
+	if baseURL, ok := inputs["base_url"].(string); ok && baseURL != "" {
+		if noBaseURL, _ := ctx.Value(noBaseURLKey{}).(bool); noBaseURL {
+			return nil, fmt.Errorf("input 'base_url' is not allowed")
+		}
+		client.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+	return client, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	return req, nil
+}
+
+// do sends req once the client's limiter admits it. The returned done
+// function must be called, with the usage reported by the response if
+// known, once the response body has been consumed.
+func (c *Client) do(req *http.Request, model string) (*http.Response, func(*Usage), error) {
+	done := func(*Usage) {}
+	if c.Limiter != nil {
+		estimate := 0
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") && req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				data, _ := io.ReadAll(body)
+				estimate = requestTokens(data)
+			}
+		}
+		release, err := c.Limiter.wait(req.Context(), c.BaseURL, model, estimate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wait for rate limit: %w", err)
+		}
+		done = func(usage *Usage) {
+			if usage != nil {
+				c.Limiter.settle(c.BaseURL, model, estimate, usage.TotalTokens)
+			}
+			release()
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		done(nil)
+		return nil, nil, fmt.Errorf("failed to execute request: %v", err)
+	}
+	if c.Limiter != nil {
+		c.Limiter.observe(c.BaseURL, model, resp.Header)
+	}
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		defer done(nil)
+		body, _ := io.ReadAll(resp.Body)
+		return nil, nil, decodeAPIError(resp.StatusCode, body)
+	}
+	return resp, done, nil
+}
+
+// call sends req in a client span and returns the response body. Token
+// usage reported in a JSON body is recorded on the span and counted for the
+// task in ctx.
+func (c *Client) call(ctx context.Context, req *http.Request, model string) ([]byte, error) {
+	ctx, span := startRequestSpan(ctx, req, model)
+	defer span.End()
+
+	resp, done, err := c.do(req.WithContext(ctx), model)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		done(nil)
+		return nil, spanError(span, fmt.Errorf("failed to read response: %v", err))
+	}
+
+	var payload struct {
+		Usage *Usage `json:"usage"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Usage != nil {
+		recordUsage(ctx, model, strings.HasSuffix(req.URL.Path, "/embeddings"), payload.Usage)
+	}
+	done(payload.Usage)
+	return body, nil
+}
+
+func (c *Client) jsonRequest(ctx context.Context, path string, data interface{}) (*http.Request, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request data: %v", err)
+	}
+
+	req, err := c.newRequest(ctx, "POST", path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// postJSONRaw posts data to path and returns the response body, from the
+// cache when the request is cacheable. Cached responses use no tokens.
+func (c *Client) postJSONRaw(ctx context.Context, path string, data map[string]interface{}) ([]byte, error) {
+	key := c.cacheKey(ctx, path, data)
+	if key != "" {
+		if body, ok := c.Cache.Get(key); ok {
+			trace.SpanFromContext(ctx).AddEvent("cache hit", trace.WithAttributes(attribute.String("url.path", path)))
+			return body, nil
+		}
+	}
+
+	req, err := c.jsonRequest(ctx, path, data)
+	if err != nil {
+		return nil, err
+	}
+	model, _ := data["model"].(string)
+	body, err := c.call(ctx, req, model)
+	if err == nil && key != "" {
+		c.Cache.Set(key, body)
+	}
+	return body, err
+}
+
+func (c *Client) postJSON(ctx context.Context, path string, data map[string]interface{}, out interface{}) error {
+	body, err := c.postJSONRaw(ctx, path, data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+type multipartFile struct {
+	Field       string
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+func (c *Client) multipartRequest(ctx context.Context, path string, fields url.Values, files []multipartFile) (*http.Request, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, values := range fields {
+		for _, value := range values {
+			if err := writer.WriteField(key, value); err != nil {
+				return nil, fmt.Errorf("failed to write form field %s: %v", key, err)
+			}
+		}
+	}
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, file.Field, file.Name))
+		header.Set("Content-Type", file.ContentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create form file %s: %v", file.Field, err)
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return nil, fmt.Errorf("failed to write form file %s: %v", file.Field, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize form: %v", err)
+	}
+
+	req, err := c.newRequest(ctx, "POST", path, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+func (c *Client) postMultipartRaw(ctx context.Context, path string, fields url.Values, files []multipartFile) ([]byte, error) {
+	req, err := c.multipartRequest(ctx, path, fields, files)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, req, fields.Get("model"))
+}
+
+func (c *Client) postMultipart(ctx context.Context, path string, fields url.Values, files []multipartFile, out interface{}) error {
+	body, err := c.postMultipartRaw(ctx, path, fields, files)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// streamChat starts a streaming chat completion and returns its StreamEvents.
+// Usage is requested for the stream unless data sets stream_options. The
+// request span ends when the stream does. The text of cacheable streams
+// that finish is cached and replayed as a single delta.
+func (c *Client) streamChat(ctx context.Context, data map[string]interface{}) (<-chan interface{}, error) {
+	if _, ok := data["stream_options"]; !ok {
+		data["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	key := c.cacheKey(ctx, "/chat/completions", data)
+	if key != "" {
+		if text, ok := c.Cache.Get(key); ok {
+			trace.SpanFromContext(ctx).AddEvent("cache hit", trace.WithAttributes(attribute.String("url.path", "/chat/completions")))
+			events := make(chan interface{}, 2)
+			events <- StreamEvent{Type: StreamDelta, Delta: string(text)}
+			events <- StreamEvent{Type: StreamFinish, FinishReason: "stop"}
+			close(events)
+			return events, nil
+		}
+	}
+
+	req, err := c.jsonRequest(ctx, "/chat/completions", data)
+	if err != nil {
+		return nil, err
+	}
+
+	model, _ := data["model"].(string)
+	ctx, span := startRequestSpan(ctx, req, model)
+	resp, done, err := c.do(req.WithContext(ctx), model)
+	if err != nil {
+		spanError(span, err)
+		span.End()
+		return nil, err
+	}
+
+	events := make(chan interface{})
+	go func() {
+		defer span.End()
+		done(readChatStream(ctx, model, resp.Body, events))
+	}()
+	if key == "" {
+		return events, nil
+	}
+
+	cached := make(chan interface{})
+	go func() {
+		defer close(cached)
+		var text strings.Builder
+		finished := false
+		for item := range events {
+			if event, ok := item.(StreamEvent); ok {
+				text.WriteString(event.Delta)
+				switch event.Type {
+				case StreamFinish:
+					finished = true
+				case StreamError:
+					finished = false
+				}
+			}
+			cached <- item
+		}
+		if finished {
+			c.Cache.Set(key, []byte(text.String()))
+		}
+	}()
+	return cached, nil
+}
+
+func (c *Client) chatCompletion(ctx context.Context, data map[string]interface{}) (string, error) {
+	var response OpenAIResponse
+	if err := c.postJSON(ctx, "/chat/completions", data, &response); err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no choices returned from OpenAI API")
+	}
+	return response.Choices[0].Message.Content, nil
+}
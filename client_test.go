@@ -0,0 +1,173 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeProvider serves routes as a local stand-in for the OpenAI API.
+func newFakeProvider(t *testing.T, routes map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, handler := range routes {
+		mux.HandleFunc(path, handler)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// providerInputs returns inputs with an API key and srv as the base URL.
+func providerInputs(srv *httptest.Server, inputs map[string]interface{}) map[string]interface{} {
+	all := map[string]interface{}{"api_key": "test-key", "base_url": srv.URL}
+	for name, value := range inputs {
+		all[name] = value
+	}
+	return all
+}
+
+// chatReply answers chat completions with content and some usage.
+func chatReply(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": content}}},
+			"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}
+}
+
+func writeTestJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// streamReply answers chat completions with a stream of deltas, a finish
+// reason and usage.
+func streamReply(deltas ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range deltas {
+			writeSSE(w, map[string]interface{}{"choices": []map[string]interface{}{{"delta": map[string]string{"content": delta}}}})
+		}
+		writeSSE(w, map[string]interface{}{"choices": []map[string]interface{}{{"delta": map[string]string{}, "finish_reason": "stop"}}})
+		writeSSE(w, map[string]interface{}{"choices": []interface{}{}, "usage": map[string]int{"prompt_tokens": 7, "completion_tokens": len(deltas), "total_tokens": 7 + len(deltas)}})
+		io.WriteString(w, "data: [DONE]\n\n")
+	}
+}
+
+func writeSSE(w io.Writer, chunk interface{}) {
+	data, _ := json.Marshal(chunk)
+	io.WriteString(w, "data: "+string(data)+"\n\n")
+}
+
+// readStream collects the text of a tool's stream and the first error
+// event.
+func readStream(stream <-chan interface{}) (string, error) {
+	var text strings.Builder
+	var err error
+	for item := range stream {
+		if event, ok := item.(StreamEvent); ok {
+			text.WriteString(event.Delta)
+			if event.Type == StreamError && err == nil {
+				err = event.Err
+			}
+		}
+	}
+	return text.String(), err
+}
+
+// decodeTestJSON decodes the JSON request body of r.
+func decodeTestJSON(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("failed to decode request body: %v", err)
+	}
+	return body
+}
+
+func TestChatCompletion(t *testing.T) {
+	var got map[string]interface{}
+	var auth string
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			got = decodeTestJSON(t, r)
+			chatReply("hello")(w, r)
+		},
+	})
+	client, err := clientFromInputs(context.Background(), providerInputs(srv, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := client.chatCompletion(context.Background(), map[string]interface{}{"model": "gpt-4o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Errorf("reply = %q, want hello", reply)
+	}
+	if auth != "Bearer test-key" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got["model"] != "gpt-4o" {
+		t.Errorf("model = %v, want gpt-4o", got["model"])
+	}
+}
+
+func TestChatCompletionAPIError(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error": {"message": "slow down", "type": "requests", "code": "rate_limit_exceeded"}}`)
+		},
+	})
+	client, _ := clientFromInputs(context.Background(), providerInputs(srv, nil))
+
+	_, err := client.chatCompletion(context.Background(), map[string]interface{}{"model": "gpt-4o"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Code != "rate_limit_exceeded" || apiErr.Message != "slow down" {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+}
+
+func TestDecodeAPIErrorPlainBody(t *testing.T) {
+	if err := decodeAPIError(http.StatusBadGateway, []byte("upstream down\n")); err.Message != "upstream down" {
+		t.Errorf("Message = %q, want the body", err.Message)
+	}
+	if err := decodeAPIError(http.StatusBadGateway, nil); err.Message != "Bad Gateway" {
+		t.Errorf("Message = %q, want the status text", err.Message)
+	}
+}
+
+func TestClientFromInputs(t *testing.T) {
+	if _, err := clientFromInputs(context.Background(), map[string]interface{}{}); err == nil {
+		t.Error("want an error without api_key")
+	}
+
+	base := &Client{APIKey: "shared", Limiter: NewRateLimiter(1)}
+	ctx := withClient(context.Background(), base)
+	client, err := clientFromInputs(ctx, map[string]interface{}{"base_url": "http://localhost:1234/v1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.APIKey != "shared" || client.Limiter != base.Limiter {
+		t.Errorf("client does not share the key and limiter of the one in ctx")
+	}
+	if client.BaseURL != "http://localhost:1234/v1" {
+		t.Errorf("BaseURL = %q", client.BaseURL)
+	}
+	if base.BaseURL != "" {
+		t.Errorf("the client in ctx was changed")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/DevMaan707/aicraft"
+	"gopkg.in/yaml.v3"
+)
+
+// approveCommand records a decision for an approval task of a run that
+// stopped while waiting, so that 'run --resume' continues with it.
+func approveCommand(args []string) error {
+	fs := flag.NewFlagSet("approve", flag.ContinueOnError)
+	storeDir := fs.String("store", "", "directory the run was checkpointed in")
+	reject := fs.Bool("reject", false, "reject instead of approving")
+	comment := fs.String("comment", "", "comment for the decision")
+	value := fs.String("value", "", "approve this value, parsed as YAML, instead of the submitted one")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 || *storeDir == "" {
+		fmt.Fprintln(fs.Output(), "usage: aicraft approve --store dir [flags] <run-id> <task-id>")
+		fs.PrintDefaults()
+		return errUsage
+	}
+
+	decision := aicraft.Decision{Approved: !*reject, Comment: *comment}
+	if *value != "" {
+		if err := yaml.Unmarshal([]byte(*value), &decision.Value); err != nil {
+			return fmt.Errorf("--value: %v", err)
+		}
+	}
+	store, err := aicraft.NewDirStore(*storeDir)
+	if err != nil {
+		return err
+	}
+	manager := aicraft.NewManager()
+	manager.Store = store
+	if err := manager.Decide(positional[0], positional[1], decision); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "decision recorded; continue with: aicraft run --store %s --resume %s <workflow>\n", *storeDir, positional[0])
+	return nil
+}
+
+var promptMu sync.Mutex
+
+// promptApprovals asks on the terminal for a decision on every pending
+// approval. When the input ends, approvals stay pending.
+func promptApprovals(manager *aicraft.Manager, input *bufio.Reader) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	for _, approval := range manager.Approvals() {
+		fmt.Fprintf(os.Stderr, "task %s needs approval", approval.TaskID)
+		if approval.Message != "" {
+			fmt.Fprintf(os.Stderr, ": %s", approval.Message)
+		}
+		fmt.Fprintf(os.Stderr, "\n%s\n", formatResult(approval.Value))
+		for {
+			fmt.Fprint(os.Stderr, "approve? [y/n] ")
+			line, err := input.ReadString('\n')
+			if err != nil {
+				return
+			}
+			answer := strings.ToLower(strings.TrimSpace(line))
+			if answer == "y" || answer == "yes" || answer == "n" || answer == "no" {
+				decision := aicraft.Decision{Approved: answer[0] == 'y'}
+				if err := manager.Decide(approval.RunID, approval.TaskID, decision); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
+				break
+			}
+		}
+	}
+}
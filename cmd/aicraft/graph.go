@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+func graphCommand(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("format", "dot", "graph format: dot or mermaid")
+	var subworkflows subworkflowFlags
+	fs.Var(&subworkflows, "subworkflow", "register a workflow file as a tool named after the file (repeatable)")
+	path, err := workflowArg(fs, args)
+	if err != nil {
+		return err
+	}
+	manager := newManager()
+	if err := subworkflows.register(manager); err != nil {
+		return err
+	}
+	config, err := loadWorkflow(manager, path)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "dot":
+		fmt.Print(dotGraph(config))
+	case "mermaid":
+		fmt.Print(mermaidGraph(config))
+	default:
+		return fmt.Errorf("unknown graph format %q", *format)
+	}
+	return nil
+}
+
+// agentLabel names the agent and lists its tasks with their tools.
+func agentLabel(config *aicraft.WorkflowConfig, agent aicraft.AgentConfig, newline string) string {
+	tools := make(map[string]string)
+	for _, task := range config.Tasks {
+		tools[task.ID] = task.ToolID
+		if task.ForEach != "" {
+			tools[task.ID] += ", for each " + task.ForEach
+		}
+	}
+
+	lines := []string{agent.ID}
+	if agent.Name != "" {
+		lines[0] = agent.Name
+	}
+	if agent.Condition != "" {
+		lines = append(lines, "if "+agent.Condition)
+	}
+	if agent.Until != "" {
+		lines = append(lines, fmt.Sprintf("until %s (at most %d times)", agent.Until, agent.MaxIterations))
+	} else if agent.MaxIterations > 1 {
+		lines = append(lines, fmt.Sprintf("%d times", agent.MaxIterations))
+	}
+	for _, taskID := range agent.Tasks {
+		lines = append(lines, fmt.Sprintf("%s (%s)", taskID, tools[taskID]))
+	}
+	return strings.Join(lines, newline)
+}
+
+func dotGraph(config *aicraft.WorkflowConfig) string {
+	var b strings.Builder
+	b.WriteString("digraph workflow {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, agent := range config.Agents {
+		fmt.Fprintf(&b, "\t%q [label=%q];\n", agent.ID, agentLabel(config, agent, "\n"))
+	}
+	for _, agent := range config.Agents {
+		for _, dep := range agent.DependsOn {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", dep, agent.ID)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+var mermaidUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func mermaidGraph(config *aicraft.WorkflowConfig) string {
+	id := func(agentID string) string {
+		return "agent_" + mermaidUnsafe.ReplaceAllString(agentID, "_")
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, agent := range config.Agents {
+		label := strings.ReplaceAll(agentLabel(config, agent, "<br/>"), `"`, "#quot;")
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", id(agent.ID), label)
+	}
+	for _, agent := range config.Agents {
+		for _, dep := range agent.DependsOn {
+			fmt.Fprintf(&b, "    %s --> %s\n", id(dep), id(agent.ID))
+		}
+	}
+	return b.String()
+}
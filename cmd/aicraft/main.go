@@ -0,0 +1,188 @@
+// Command aicraft runs, validates and inspects workflow files.
+//
+//	aicraft run [--subworkflow file] [--set task.input=value] [--concurrency n] [--timeout d] [--output text|json] [--store dir [--resume id] | --dry-run] <workflow.yaml>
+//	aicraft validate [--subworkflow file] <workflow.yaml>
+//	aicraft plan [--subworkflow file] [--set task.input=value] [--output text|json] <workflow.yaml>
+//	aicraft graph [--subworkflow file] [--format dot|mermaid] <workflow.yaml>
+//	aicraft tools [--output text|json]
+//	aicraft serve [--addr 127.0.0.1:8080] [--keep-runs n] [--run-ttl d] [--shutdown-timeout d] [workflow.yaml...]
+//	aicraft approve --store dir [--reject] [--comment text] [--value v] <run-id> <task-id>
+//
+// The API key is read from OPENAI_API_KEY, also from a .env file in the
+// working directory, unless the workflow sets api_key inputs.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DevMaan707/aicraft"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: aicraft <command> [flags] [arguments]
+
+commands:
+  run <workflow>       run a workflow and print its results
+  validate <workflow>  check a workflow without running it
+  plan <workflow>      print the stages of a run with estimated tokens and cost
+  graph <workflow>     print the agent graph as DOT or Mermaid
+  tools                list the registered tools and their inputs
+  serve [workflows]    serve the HTTP API, registering the given workflows
+  approve <run> <task> decide on an approval of a checkpointed run
+
+Run 'aicraft <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "run":
+		err = runCommand(args)
+	case "validate":
+		err = validateCommand(args)
+	case "plan":
+		err = planCommand(args)
+	case "graph":
+		err = graphCommand(args)
+	case "tools":
+		err = toolsCommand(args)
+	case "serve":
+		err = serveCommand(args)
+	case "approve":
+		err = approveCommand(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "aicraft: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "aicraft: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// errUsage is returned after a usage message has been printed.
+var errUsage = errors.New("usage")
+
+// parseArgs parses flags that may appear before and after the positional
+// arguments, which it returns.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// workflowArg parses args and returns the single workflow path they name.
+func workflowArg(fs *flag.FlagSet, args []string) (string, error) {
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) != 1 {
+		fmt.Fprintf(fs.Output(), "usage: aicraft %s [flags] <workflow>\n", fs.Name())
+		fs.PrintDefaults()
+		return "", errUsage
+	}
+	return paths[0], nil
+}
+
+func newManager() *aicraft.Manager {
+	godotenv.Load()
+
+	manager := aicraft.NewManager()
+	manager.Client.APIKey = os.Getenv("OPENAI_API_KEY")
+	if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+		manager.Client.BaseURL = baseURL
+	}
+	return manager
+}
+
+// subworkflowFlags collects --subworkflow files.
+type subworkflowFlags []string
+
+func (s *subworkflowFlags) String() string { return strings.Join(*s, ",") }
+
+func (s *subworkflowFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// register registers the workflow files as tools of manager, each named
+// after its file, so tasks can run them as sub-workflows. A file may use the
+// sub-workflows given before it.
+func (s subworkflowFlags) register(manager *aicraft.Manager) error {
+	for _, path := range s {
+		config, err := aicraft.LoadWorkflowConfig(path)
+		if err != nil {
+			return err
+		}
+		name := workflowName(path)
+		if err := manager.RegisterWorkflow(name, name, *config); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// workflowName names the workflow at path after its file.
+func workflowName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// loadWorkflow loads and validates the workflow at path.
+func loadWorkflow(manager *aicraft.Manager, path string) (*aicraft.WorkflowConfig, error) {
+	config, err := aicraft.LoadWorkflowConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.ValidateWorkflow(*config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func validateCommand(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var subworkflows subworkflowFlags
+	fs.Var(&subworkflows, "subworkflow", "register a workflow file as a tool named after the file (repeatable)")
+	path, err := workflowArg(fs, args)
+	if err != nil {
+		return err
+	}
+	manager := newManager()
+	if err := subworkflows.register(manager); err != nil {
+		return err
+	}
+	config, err := loadWorkflow(manager, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d tasks, %d agents\n", path, len(config.Tasks), len(config.Agents))
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+func TestParseArgsInterleavesFlags(t *testing.T) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var sets setFlags
+	fs.Var(&sets, "set", "")
+	output := fs.String("output", "text", "")
+
+	paths, err := parseArgs(fs, []string{"--set", "a.x=1", "workflow.yaml", "--output", "json", "--set", "a.y=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(paths, []string{"workflow.yaml"}) || *output != "json" {
+		t.Errorf("paths = %v, output = %q", paths, *output)
+	}
+	if want := (setFlags{"a.x=1", "a.y=2"}); !reflect.DeepEqual(sets, want) {
+		t.Errorf("sets = %v, want %v", sets, want)
+	}
+
+	fs = flag.NewFlagSet("graph", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := workflowArg(fs, nil); err != errUsage {
+		t.Errorf("workflowArg without a path = %v, want errUsage", err)
+	}
+}
+
+func TestSetFlagsApply(t *testing.T) {
+	config := &aicraft.WorkflowConfig{Tasks: []aicraft.TaskConfig{{ID: "t", Inputs: map[string]interface{}{"model": "gpt-4o"}}, {ID: "u"}}}
+	sets := setFlags{"t.max_tokens=200", "t.stream=true", "u.prompt=a=b: c", "t.model=gpt-4o-mini"}
+	if err := sets.apply(config); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"model": "gpt-4o-mini", "max_tokens": 200, "stream": true}
+	if !reflect.DeepEqual(config.Tasks[0].Inputs, want) {
+		t.Errorf("t inputs = %#v, want %#v", config.Tasks[0].Inputs, want)
+	}
+	if prompt := config.Tasks[1].Inputs["prompt"]; prompt != "a=b: c" {
+		t.Errorf("u prompt = %#v, want the raw string", prompt)
+	}
+
+	for _, set := range []string{"t.model", "model=x", "missing.model=x"} {
+		if err := (setFlags{set}).apply(config); err == nil {
+			t.Errorf("apply(%q) succeeded", set)
+		}
+	}
+}
+
+func TestGraphs(t *testing.T) {
+	config := &aicraft.WorkflowConfig{
+		Tasks: []aicraft.TaskConfig{{ID: "draft", ToolID: "content_generator"}},
+		Agents: []aicraft.AgentConfig{
+			{ID: "writer", Tasks: []string{"draft"}},
+			{ID: "review-1", Name: `Say "hi"`, DependsOn: []string{"writer"}, Condition: "writer.ok"},
+		},
+	}
+
+	dot := dotGraph(config)
+	for _, want := range []string{`"writer" [label="writer\ndraft (content_generator)"];`, `"writer" -> "review-1";`, `if writer.ok`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT graph has no %q:\n%s", want, dot)
+		}
+	}
+	mermaid := mermaidGraph(config)
+	for _, want := range []string{`agent_review_1["Say #quot;hi#quot;<br/>if writer.ok"]`, "agent_writer --> agent_review_1"} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Mermaid graph has no %q:\n%s", want, mermaid)
+		}
+	}
+}
+
+func TestRegisterSubworkflows(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"inner.yaml": "tasks:\n  - id: t\n    tool: image_generator\nagents:\n  - id: a\n    tasks: [t]\ninputs:\n  - name: prompt\n    to: [t.prompt]\noutputs:\n  images: t\n",
+		"outer.yaml": "tasks:\n  - id: call\n    tool: inner\nagents:\n  - id: a\n    tasks: [call]\noutputs:\n  result: call\n",
+	}
+	var subworkflows subworkflowFlags
+	for _, name := range []string{"inner.yaml", "outer.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		subworkflows.Set(path)
+	}
+
+	manager := newManager()
+	if err := subworkflows.register(manager); err != nil {
+		t.Fatal(err)
+	}
+	if manager.Tools["inner"] == nil || manager.Tools["outer"] == nil {
+		t.Error("the workflows were not registered as tools named after their files")
+	}
+	if err := (subworkflowFlags{filepath.Join(dir, "outer.yaml")}).register(newManager()); err == nil {
+		t.Error("a sub-workflow was registered before the one it uses")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+func planCommand(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	var sets setFlags
+	fs.Var(&sets, "set", "override a task input as task_id.input=value (repeatable)")
+	var subworkflows subworkflowFlags
+	fs.Var(&subworkflows, "subworkflow", "register a workflow file as a tool named after the file (repeatable)")
+	output := fs.String("output", "text", "output format: text or json")
+	path, err := workflowArg(fs, args)
+	if err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	manager := newManager()
+	if err := subworkflows.register(manager); err != nil {
+		return err
+	}
+	config, err := aicraft.LoadWorkflowConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := sets.apply(config); err != nil {
+		return err
+	}
+	if err := manager.ValidateWorkflow(*config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := manager.InitializeWorkflow(*config); err != nil {
+		return err
+	}
+	plan, err := manager.Plan()
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+	for i, stage := range plan.Stages {
+		fmt.Printf("stage %d\n", i+1)
+		for _, agent := range stage.Agents {
+			fmt.Printf("  agent %s", agent.ID)
+			if agent.Condition != "" {
+				fmt.Printf(" if %s", agent.Condition)
+			}
+			if agent.Until != "" {
+				fmt.Printf(" until %s (at most %d times)", agent.Until, agent.MaxIterations)
+			} else if agent.MaxIterations > 1 {
+				fmt.Printf(" %d times", agent.MaxIterations)
+			}
+			fmt.Println()
+			for _, task := range agent.Tasks {
+				fmt.Printf("    %s\n", planTaskLine(task))
+			}
+		}
+	}
+	estimate := plan.Estimate
+	fmt.Printf("estimated %d requests, %d tokens, $%.4f", estimate.Requests, estimate.TotalTokens, estimate.Cost)
+	if plan.Partial {
+		fmt.Print(" (partial: some tasks are not fully estimated)")
+	}
+	fmt.Println()
+	return nil
+}
+
+// planTaskLine describes a task of a plan with its tool, the inputs it
+// takes from other tasks and its estimate.
+func planTaskLine(task aicraft.PlanTask) string {
+	line := fmt.Sprintf("%s (%s", task.ID, task.ToolID)
+	if task.ForEach != "" {
+		line += ", for each " + task.ForEach
+	}
+	line += ")"
+	if len(task.InputsFrom) > 0 {
+		from := make([]string, 0, len(task.InputsFrom))
+		for input, source := range task.InputsFrom {
+			from = append(from, input+" <- "+source)
+		}
+		sort.Strings(from)
+		line += " " + strings.Join(from, ", ")
+	}
+	switch {
+	case task.Estimate != nil:
+		line += ": " + task.Model
+		if task.Estimate.TotalTokens > 0 {
+			line += fmt.Sprintf(", ~%d tokens", task.Estimate.TotalTokens)
+		}
+		line += fmt.Sprintf(", $%.4f", task.Estimate.Cost)
+		if task.Partial {
+			line += " (partial)"
+		}
+	case task.Partial:
+		line += ": no estimate"
+	}
+	return line
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DevMaan707/aicraft"
+	"gopkg.in/yaml.v3"
+)
+
+// setFlags collects --set task_id.input=value overrides.
+type setFlags []string
+
+func (s *setFlags) String() string { return strings.Join(*s, ",") }
+
+func (s *setFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// apply sets the overridden inputs in config. Values are parsed as YAML
+// scalars, so numbers and booleans keep their types.
+func (s setFlags) apply(config *aicraft.WorkflowConfig) error {
+	for _, set := range s {
+		key, value, ok := strings.Cut(set, "=")
+		taskID, input, dotted := strings.Cut(key, ".")
+		if !ok || !dotted || taskID == "" || input == "" {
+			return fmt.Errorf("invalid --set %q, want task_id.input=value", set)
+		}
+
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil || parsed == nil {
+			parsed = value
+		}
+		switch parsed.(type) {
+		case map[string]interface{}, []interface{}:
+			parsed = value
+		}
+
+		found := false
+		for i := range config.Tasks {
+			if config.Tasks[i].ID != taskID {
+				continue
+			}
+			if config.Tasks[i].Inputs == nil {
+				config.Tasks[i].Inputs = make(map[string]interface{})
+			}
+			config.Tasks[i].Inputs[input] = parsed
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("--set %q: unknown task %s", set, taskID)
+		}
+	}
+	return nil
+}
+
+type runOutput struct {
+	RunID    string                 `json:"run_id"`
+	Status   string                 `json:"status"`
+	Error    string                 `json:"error,omitempty"`
+	Duration string                 `json:"duration"`
+	Results  map[string]interface{} `json:"results"`
+	Skipped  []string               `json:"skipped,omitempty"`
+	Usage    aicraft.UsageReport    `json:"usage"`
+}
+
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var sets setFlags
+	fs.Var(&sets, "set", "override a task input as task_id.input=value (repeatable)")
+	var subworkflows subworkflowFlags
+	fs.Var(&subworkflows, "subworkflow", "register a workflow file as a tool named after the file (repeatable)")
+	concurrency := fs.Int("concurrency", 1, "maximum provider requests in flight; above 1, ready agents run in parallel")
+	timeout := fs.Duration("timeout", 0, "abort the run after this long")
+	output := fs.String("output", "text", "output format: text or json")
+	storeDir := fs.String("store", "", "checkpoint the run in this directory so it can be resumed")
+	resume := fs.String("resume", "", "resume the run with this ID from --store, skipping the tasks that succeeded")
+	dryRun := fs.Bool("dry-run", false, "run without executing tools, giving every task a placeholder result")
+	path, err := workflowArg(fs, args)
+	if err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	if *resume != "" && *storeDir == "" {
+		return fmt.Errorf("--resume requires --store")
+	}
+	if *dryRun && *storeDir != "" {
+		return fmt.Errorf("--dry-run cannot be used with --store")
+	}
+
+	manager := newManager()
+	if err := subworkflows.register(manager); err != nil {
+		return err
+	}
+	config, err := aicraft.LoadWorkflowConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := sets.apply(config); err != nil {
+		return err
+	}
+	if err := manager.ValidateWorkflow(*config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := manager.InitializeWorkflow(*config); err != nil {
+		return err
+	}
+	if *storeDir != "" {
+		store, err := aicraft.NewDirStore(*storeDir)
+		if err != nil {
+			return err
+		}
+		manager.Store = store
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var run *aicraft.Run
+	if *resume != "" {
+		run, err = manager.ResumeRun(*resume)
+	} else {
+		run, err = manager.NewRun()
+	}
+	if err != nil {
+		return err
+	}
+	run.DryRun = *dryRun
+
+	var (
+		mu       sync.Mutex
+		failures []error
+	)
+	stdin := bufio.NewReader(os.Stdin)
+	manager.Events.OnEvent(func(event aicraft.Event) {
+		if event.Type == aicraft.ApprovalRequested {
+			go promptApprovals(manager, stdin)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Type == aicraft.TaskFailed {
+			failures = append(failures, fmt.Errorf("task %s: %w", event.TaskID, event.Err))
+		}
+		if *output == "text" {
+			printProgress(event)
+		}
+	})
+
+	start := time.Now()
+	if *concurrency > 1 {
+		manager.Client.Limiter = aicraft.NewRateLimiter(*concurrency)
+		err = run.ExecuteConcurrently(ctx)
+	} else {
+		err = run.Execute(ctx)
+	}
+	mu.Lock()
+	if err == nil && len(failures) > 0 {
+		err = errors.Join(failures...)
+	}
+	mu.Unlock()
+
+	results := run.Results()
+
+	if *output == "json" {
+		out := runOutput{
+			RunID:    run.ID,
+			Status:   "succeeded",
+			Duration: time.Since(start).String(),
+			Results:  results,
+			Skipped:  run.Skipped(),
+			Usage:    run.Usage(),
+		}
+		if err != nil {
+			out.Status = "failed"
+			out.Error = err.Error()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(out); encodeErr != nil {
+			return encodeErr
+		}
+		return err
+	}
+
+	for _, taskConfig := range config.Tasks {
+		result, ok := results[taskConfig.ID]
+		if !ok {
+			continue
+		}
+		fmt.Printf("== %s ==\n%s\n", taskConfig.ID, formatResult(result))
+	}
+	if manager.Store != nil {
+		fmt.Fprintf(os.Stderr, "run %s checkpointed in %s\n", run.ID, *storeDir)
+	}
+	usage := run.Usage().Total
+	fmt.Fprintf(os.Stderr, "%d requests, %d tokens, $%.4f in %s\n", usage.Requests, usage.TotalTokens, usage.Cost, time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+func printProgress(event aicraft.Event) {
+	switch event.Type {
+	case aicraft.TaskStarted:
+		fmt.Fprintf(os.Stderr, "task %s started (attempt %d)\n", event.TaskID, event.Attempt)
+	case aicraft.TaskSucceeded:
+		fmt.Fprintf(os.Stderr, "task %s succeeded in %s\n", event.TaskID, event.Duration.Round(time.Millisecond))
+	case aicraft.TaskRetried:
+		fmt.Fprintf(os.Stderr, "task %s failed, retrying: %v\n", event.TaskID, event.Err)
+	case aicraft.TaskFailed:
+		fmt.Fprintf(os.Stderr, "task %s failed: %v\n", event.TaskID, event.Err)
+	case aicraft.TaskSkipped:
+		fmt.Fprintf(os.Stderr, "task %s already succeeded, skipped\n", event.TaskID)
+	case aicraft.AgentSkipped:
+		fmt.Fprintf(os.Stderr, "agent %s skipped\n", event.AgentID)
+	case aicraft.ApprovalRequested:
+		fmt.Fprintf(os.Stderr, "task %s is waiting for approval\n", event.TaskID)
+	case aicraft.AgentIteration:
+		fmt.Fprintf(os.Stderr, "agent %s finished iteration %d\n", event.AgentID, event.Attempt)
+	}
+}
+
+// formatResult prints strings as they are and other results as JSON.
+func formatResult(result interface{}) string {
+	if text, ok := result.(string); ok {
+		return text
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Sprint(result)
+	}
+	return string(data)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/DevMaan707/aicraft"
+	"github.com/DevMaan707/aicraft/server"
+)
+
+func serveCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on; the server has no authentication")
+	keepRuns := fs.Int("keep-runs", 1000, "how many finished runs to keep (0 keeps all)")
+	runTTL := fs.Duration("run-ttl", time.Hour, "how long to keep finished runs (0 keeps them until --keep-runs evicts them)")
+	grace := fs.Duration("shutdown-timeout", 30*time.Second, "how long to wait for running workflows on shutdown")
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	manager := newManager()
+	srv := server.New()
+	srv.MaxFinishedRuns = *keepRuns
+	srv.FinishedRunTTL = *runTTL
+	srv.NewManager = func() *aicraft.Manager {
+		m := aicraft.NewManager()
+		m.Client = manager.Client
+		return m
+	}
+	for _, path := range paths {
+		config, err := loadWorkflow(manager, path)
+		if err != nil {
+			return err
+		}
+		name := workflowName(path)
+		srv.RegisterWorkflow(name, *config)
+		// Workflows with inputs or outputs are also sub-workflows of the
+		// files after them, as they are of runs.
+		if len(config.Inputs) > 0 || len(config.Outputs) > 0 {
+			if err := manager.RegisterWorkflow(name, name, *config); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "registered workflow %s\n", name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe(*addr)
+	}()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	fmt.Fprintln(os.Stderr, "shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
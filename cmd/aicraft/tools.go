@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+func toolsCommand(args []string) error {
+	fs := flag.NewFlagSet("tools", flag.ContinueOnError)
+	output := fs.String("output", "text", "output format: text or json")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	manager := aicraft.NewManager()
+	ids := make([]string, 0, len(manager.Tools))
+	for id := range manager.Tools {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	switch *output {
+	case "json":
+		type toolOutput struct {
+			ID     string              `json:"id"`
+			Name   string              `json:"name"`
+			Inputs []aicraft.ToolInput `json:"inputs"`
+		}
+		tools := make([]toolOutput, 0, len(ids))
+		for _, id := range ids {
+			tool := manager.Tools[id]
+			tools = append(tools, toolOutput{ID: tool.ID, Name: tool.Name, Inputs: tool.Inputs})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tools)
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for i, id := range ids {
+			tool := manager.Tools[id]
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", tool.ID, tool.Name)
+			for _, input := range tool.Inputs {
+				required := ""
+				if input.Required {
+					required = "required"
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", input.Name, input.Type, required, input.Description)
+			}
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+}
@@ -0,0 +1,251 @@
+package aicraft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// workflowFile is the layout of a workflow file: a WorkflowConfig that may
+// include other files.
+type workflowFile struct {
+	Include        []string `yaml:"include"`
+	WorkflowConfig `yaml:",inline"`
+}
+
+// LoadWorkflowConfig reads a workflow from a YAML or JSON file.
+//
+// String values may reference environment variables as ${NAME} or
+// ${NAME:-default}; referencing an unset variable without a default is an
+// error. Files listed under 'include', relative to the including file, are
+// loaded first and their tasks, agents and inputs are added before the
+// file's own; their outputs are merged, and an output may only name one task.
+// Unknown fields are reported with their file and line.
+func LoadWorkflowConfig(path string) (*WorkflowConfig, error) {
+	config := &WorkflowConfig{}
+	if err := loadWorkflowFile(path, config, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[string]bool)
+	for _, task := range config.Tasks {
+		if tasks[task.ID] {
+			return nil, fmt.Errorf("%s: task %s is defined more than once", path, task.ID)
+		}
+		tasks[task.ID] = true
+	}
+	agents := make(map[string]bool)
+	for _, agent := range config.Agents {
+		if agents[agent.ID] {
+			return nil, fmt.Errorf("%s: agent %s is defined more than once", path, agent.ID)
+		}
+		agents[agent.ID] = true
+	}
+	inputs := make(map[string]bool)
+	for _, input := range config.Inputs {
+		if inputs[input.Name] {
+			return nil, fmt.Errorf("%s: input %s is defined more than once", path, input.Name)
+		}
+		inputs[input.Name] = true
+	}
+	return config, nil
+}
+
+func loadWorkflowFile(path string, config *WorkflowConfig, loading map[string]bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if loading[absPath] {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	loading[absPath] = true
+	defer delete(loading, absPath)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read workflow: %w", err)
+	}
+	file, err := decodeWorkflowFile(data, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, include := range file.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := loadWorkflowFile(include, config, loading); err != nil {
+			return err
+		}
+	}
+	config.Tasks = append(config.Tasks, file.Tasks...)
+	config.Agents = append(config.Agents, file.Agents...)
+	config.Inputs = append(config.Inputs, file.Inputs...)
+	for output, taskID := range file.Outputs {
+		if config.Outputs == nil {
+			config.Outputs = make(map[string]string)
+		}
+		if previous, ok := config.Outputs[output]; ok && previous != taskID {
+			return fmt.Errorf("%s: output %s is already the result of task %s", path, output, previous)
+		}
+		config.Outputs[output] = taskID
+	}
+	return nil
+}
+
+// ParseWorkflowConfig decodes a workflow from YAML or JSON data, reporting
+// unknown fields with their line. Unlike LoadWorkflowConfig it neither
+// interpolates environment variables nor supports includes, so it is safe
+// for definitions from untrusted sources.
+func ParseWorkflowConfig(data []byte) (*WorkflowConfig, error) {
+	file, err := decodeWorkflowFile(data, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Include) > 0 {
+		return nil, fmt.Errorf("includes are not supported here")
+	}
+	return &file.WorkflowConfig, nil
+}
+
+func decodeWorkflowFile(data []byte, interpolate bool) (*workflowFile, error) {
+	var file workflowFile
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return &file, nil
+	}
+	root := document.Content[0]
+
+	if err := checkFields(root, reflect.TypeOf(file)); err != nil {
+		return nil, err
+	}
+	if interpolate {
+		if err := interpolateEnv(root); err != nil {
+			return nil, err
+		}
+	}
+	if err := root.Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// checkFields reports mapping keys that do not match a field of the struct
+// type they are decoded into. Maps are free-form and not checked.
+func checkFields(node *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, item := range node.Content {
+			if err := checkFields(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				return fmt.Errorf("line %d: unknown field %q in %s", key.Line, key.Value, configName(t))
+			}
+			if err := checkFields(node.Content[i+1], fieldType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configName names t in errors: "task" for TaskConfig and so on.
+func configName(t reflect.Type) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(t.Name(), "Config"), "File")
+	return strings.ToLower(name)
+}
+
+// yamlFields returns the types of the fields of t by their yaml names,
+// including the fields of inlined structs.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if options == "inline" {
+			for name, fieldType := range yamlFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces ${NAME} and ${NAME:-default} in the scalar values
+// of node. Unquoted values are re-resolved afterwards, so "${RETRIES}" can
+// be decoded as a number.
+func interpolateEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		var err error
+		node.Value = envPattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			groups := envPattern.FindStringSubmatch(match)
+			if value, ok := os.LookupEnv(groups[1]); ok {
+				return value
+			}
+			if groups[2] != "" {
+				return groups[3]
+			}
+			if err == nil {
+				err = fmt.Errorf("line %d: environment variable %s is not set", node.Line, groups[1])
+			}
+			return ""
+		})
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		return err
+	}
+
+	for i, child := range node.Content {
+		// Keys of mappings are left as written.
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := interpolateEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package aicraft
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, keyed by name, to a temporary directory and
+// returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadWorkflowConfigYAML(t *testing.T) {
+	t.Setenv("AICRAFT_TEST_KEY", "secret")
+	t.Setenv("AICRAFT_TEST_RETRIES", "3")
+	dir := writeFiles(t, map[string]string{"workflow.yaml": `
+tasks:
+  - id: summary
+    tool: content_generator
+    retries: ${AICRAFT_TEST_RETRIES}
+    inputs:
+      api_key: ${AICRAFT_TEST_KEY}
+      model: ${AICRAFT_TEST_MODEL:-gpt-4o}
+      quoted: "${AICRAFT_TEST_RETRIES}"
+agents:
+  - id: writer
+    tasks: [summary]
+`})
+
+	config, err := LoadWorkflowConfig(filepath.Join(dir, "workflow.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := config.Tasks[0]
+	if task.Retries != 3 {
+		t.Errorf("Retries = %d, want 3 from the environment", task.Retries)
+	}
+	want := map[string]interface{}{"api_key": "secret", "model": "gpt-4o", "quoted": "3"}
+	if !reflect.DeepEqual(task.Inputs, want) {
+		t.Errorf("Inputs = %#v, want %#v", task.Inputs, want)
+	}
+	if len(config.Agents) != 1 || !reflect.DeepEqual(config.Agents[0].Tasks, []string{"summary"}) {
+		t.Errorf("Agents = %+v", config.Agents)
+	}
+}
+
+func TestLoadWorkflowConfigJSON(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"workflow.json": `{
+  "tasks": [{"id": "t", "tool": "echo", "inputs": {"value": 1}}],
+  "agents": [{"id": "a", "tasks": ["t"]}]
+}`})
+
+	config, err := LoadWorkflowConfig(filepath.Join(dir, "workflow.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Tasks) != 1 || config.Tasks[0].ToolID != "echo" || config.Tasks[0].Inputs["value"] != 1 {
+		t.Errorf("Tasks = %+v", config.Tasks)
+	}
+}
+
+func TestLoadWorkflowConfigIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shared/tasks.yaml": `
+tasks:
+  - id: shared
+    tool: echo
+inputs:
+  - name: topic
+    to: [shared.value]
+outputs:
+  text: shared
+`,
+		"workflow.yaml": `
+include: [shared/tasks.yaml]
+tasks:
+  - id: own
+    tool: echo
+agents:
+  - id: a
+    tasks: [shared, own]
+inputs:
+  - name: tone
+    to: [own.value]
+outputs:
+  text: shared
+  own: own
+`,
+	})
+
+	config, err := LoadWorkflowConfig(filepath.Join(dir, "workflow.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tasks, inputs []string
+	for _, task := range config.Tasks {
+		tasks = append(tasks, task.ID)
+	}
+	for _, input := range config.Inputs {
+		inputs = append(inputs, input.Name)
+	}
+	if want := []string{"shared", "own"}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("tasks = %v, want the included ones first: %v", tasks, want)
+	}
+	if want := []string{"topic", "tone"}; !reflect.DeepEqual(inputs, want) {
+		t.Errorf("inputs = %v, want %v", inputs, want)
+	}
+	if want := map[string]string{"text": "shared", "own": "own"}; !reflect.DeepEqual(config.Outputs, want) {
+		t.Errorf("Outputs = %v, want %v", config.Outputs, want)
+	}
+}
+
+func TestLoadWorkflowConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "unknown field",
+			files: map[string]string{"workflow.yaml": "tasks:\n  - id: t\n    tool: echo\n    retry: 2\n"},
+			want:  `line 4: unknown field "retry" in task`,
+		},
+		{
+			name:  "unset variable",
+			files: map[string]string{"workflow.yaml": "tasks:\n  - id: t\n    tool: ${AICRAFT_TEST_UNSET}\n"},
+			want:  "line 3: environment variable AICRAFT_TEST_UNSET is not set",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"workflow.yaml": "include: [other.yaml]\n",
+				"other.yaml":    "include: [workflow.yaml]\n",
+			},
+			want: "include cycle",
+		},
+		{
+			name: "duplicate task",
+			files: map[string]string{
+				"workflow.yaml": "include: [other.yaml]\ntasks:\n  - id: t\n    tool: echo\n",
+				"other.yaml":    "tasks:\n  - id: t\n    tool: echo\n",
+			},
+			want: "task t is defined more than once",
+		},
+		{
+			name: "duplicate input",
+			files: map[string]string{
+				"workflow.yaml": "include: [other.yaml]\ninputs:\n  - name: topic\n",
+				"other.yaml":    "inputs:\n  - name: topic\n",
+			},
+			want: "input topic is defined more than once",
+		},
+		{
+			name: "conflicting output",
+			files: map[string]string{
+				"workflow.yaml": "include: [other.yaml]\noutputs:\n  text: b\n",
+				"other.yaml":    "outputs:\n  text: a\n",
+			},
+			want: "output text is already the result of task a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.files)
+			_, err := LoadWorkflowConfig(filepath.Join(dir, "workflow.yaml"))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("LoadWorkflowConfig() = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkflowConfig(t *testing.T) {
+	t.Setenv("AICRAFT_TEST_KEY", "secret")
+	config, err := ParseWorkflowConfig([]byte("tasks:\n  - id: t\n    tool: echo\n    inputs:\n      api_key: ${AICRAFT_TEST_KEY}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := config.Tasks[0].Inputs["api_key"]; key != "${AICRAFT_TEST_KEY}" {
+		t.Errorf("api_key = %v, want the reference left as written", key)
+	}
+	if _, err := ParseWorkflowConfig([]byte("include: [other.yaml]\n")); err == nil {
+		t.Error("ParseWorkflowConfig accepted an include")
+	}
+}
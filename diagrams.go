@@ -0,0 +1,294 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var DiagramTypes = []string{"flowchart", "sequence", "architecture", "chart", "mindmap", "table", "illustration"}
+
+type DiagramItem struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	// Paragraph is the zero-based index of the paragraph the figure follows
+	// and Offset the byte offset in the content where it should be inserted.
+	Paragraph int    `json:"paragraph"`
+	Offset    int    `json:"offset"`
+	ImageURL  string `json:"image_url,omitempty"`
+	ImagePath string `json:"image_path,omitempty"`
+}
+
+type DocumentWithFigures struct {
+	Text    string        `json:"text"`
+	Figures []DiagramItem `json:"figures"`
+}
+
+// Markdown returns the text with every figure inserted as a markdown image
+// after the paragraph it was planned for.
+func (d DocumentWithFigures) Markdown() string {
+	figures := append([]DiagramItem(nil), d.Figures...)
+	sort.SliceStable(figures, func(i, j int) bool { return figures[i].Offset < figures[j].Offset })
+
+	var b strings.Builder
+	last := 0
+	for _, figure := range figures {
+		if figure.Offset < last || figure.Offset > len(d.Text) {
+			continue
+		}
+		b.WriteString(d.Text[last:figure.Offset])
+		src := figure.ImagePath
+		if src == "" {
+			src = figure.ImageURL
+		}
+		if src != "" {
+			b.WriteString(fmt.Sprintf("\n\n![%s](%s)", figure.Title, src))
+		}
+		last = figure.Offset
+	}
+	b.WriteString(d.Text[last:])
+	return b.String()
+}
+
+var (
+	DiagramPlannerTool = &Tool{
+		ID:   "diagram_planner",
+		Name: "Diagram Planner",
+		Inputs: append([]ToolInput{
+			{Name: "content", Type: "string", Required: true, Description: "text to plan diagrams for"},
+			{Name: "model", Type: "string", Description: "chat model, default gpt-3.5-turbo"},
+			{Name: "max_diagrams", Type: "int", Description: "maximum number of diagrams"},
+			{Name: "generate_images", Type: "bool", Description: "also generate the diagrams"},
+			{Name: "image_model", Type: "string", Description: "image model for generated diagrams"},
+			{Name: "image_size", Type: "string", Description: "size of generated diagrams"},
+			{Name: "quality", Type: "string", Description: "image quality"},
+			{Name: "style", Type: "string", Description: "image style"},
+			{Name: "response_format", Type: "string", Description: "url or b64_json"},
+			{Name: "output_dir", Type: "string", Description: "directory to store the diagrams in"},
+			{Name: "image_sink", Type: "ImageSink", Description: "sink to store the diagrams in"},
+		}, clientInputs...),
+		Estimate: estimateDiagrams,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			content, ok := inputs["content"].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("input 'content' is required and must be a string")
+			}
+
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+			generateImages, _ := inputs["generate_images"].(bool)
+			maxDiagrams, _ := inputs["max_diagrams"].(int)
+
+			model := "gpt-3.5-turbo"
+			if m, ok := inputs["model"].(string); ok && m != "" {
+				model = m
+			}
+
+			paragraphs := splitParagraphs(content)
+			if len(paragraphs) == 0 {
+				return nil, nil, fmt.Errorf("input 'content' must not be empty")
+			}
+
+			var numbered strings.Builder
+			for i, p := range paragraphs {
+				numbered.WriteString(fmt.Sprintf("[%d] %s\n\n", i, content[p.start:p.end]))
+			}
+
+			instructions := fmt.Sprintf("Identify the places in the following numbered paragraphs where a diagram, chart or flowchart would help the reader. "+
+				"Respond with a JSON object of the form {\"diagrams\": [{\"title\": string, \"description\": string, \"type\": string, \"after_paragraph\": int}]}. "+
+				"The description must be detailed enough to draw the figure from. The type must be one of: %s. "+
+				"after_paragraph is the number of the paragraph the figure should follow. Return an empty list if no figures are needed.",
+				strings.Join(DiagramTypes, ", "))
+			if maxDiagrams > 0 {
+				instructions += fmt.Sprintf(" Return at most %d diagrams.", maxDiagrams)
+			}
+
+			data := map[string]interface{}{
+				"model":           model,
+				"temperature":     0,
+				"response_format": map[string]string{"type": "json_object"},
+				"messages": []map[string]string{
+					{"role": "system", "content": "You are an assistant that plans diagrams and flowcharts for documents."},
+					{"role": "user", "content": instructions + "\n\n" + numbered.String()},
+				},
+			}
+
+			if verbose {
+				log.Printf("Planning diagrams for %d paragraphs with model %s", len(paragraphs), model)
+			}
+
+			reply, err := client.chatCompletion(ctx, data)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			items, err := parseDiagramPlan(reply, paragraphs)
+			if err != nil {
+				return nil, nil, err
+			}
+			if maxDiagrams > 0 && len(items) > maxDiagrams {
+				items = items[:maxDiagrams]
+			}
+
+			if verbose {
+				log.Printf("Planned %d diagrams", len(items))
+			}
+
+			if !generateImages {
+				return DocumentWithFigures{Text: content, Figures: items}, nil, nil
+			}
+
+			for i := range items {
+				imageInputs := map[string]interface{}{
+					"description": diagramPrompt(items[i]),
+					"verbose":     verbose,
+				}
+				for _, key := range []string{"quality", "style", "response_format", "output_dir", "image_sink"} {
+					if v, ok := inputs[key]; ok {
+						imageInputs[key] = v
+					}
+				}
+				if v, ok := inputs["image_model"]; ok {
+					imageInputs["model"] = v
+				}
+				if v, ok := inputs["image_size"]; ok {
+					imageInputs["size"] = v
+				}
+				result, _, err := ImageGeneratorTool.ExecuteContext(withClient(ctx, client), imageInputs)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to generate image for diagram %q: %v", items[i].Title, err)
+				}
+				switch image := result.(type) {
+				case string:
+					items[i].ImageURL = image
+				case []GeneratedImage:
+					if len(image) > 0 {
+						// Images that are not stored are kept inline so that
+						// Markdown can still show them.
+						items[i].ImageURL = image[0].URL
+						if items[i].ImageURL == "" {
+							items[i].ImageURL = image[0].dataURL()
+						}
+						items[i].ImagePath = image[0].Path
+					}
+				}
+			}
+
+			return DocumentWithFigures{Text: content, Figures: items}, nil, nil
+		},
+	}
+
+	// ImageNeedCheckerTool plans diagrams for 'content' like
+	// DiagramPlannerTool but returns their descriptions as a JSON array of
+	// strings, as it did before the planner replaced it. It is not
+	// registered in managers.
+	//
+	// Deprecated: use DiagramPlannerTool.
+	ImageNeedCheckerTool = &Tool{
+		ID:   "image_need_checker",
+		Name: "Image Need Checker",
+		Inputs: append([]ToolInput{
+			{Name: "content", Type: "string", Required: true, Description: "text to check"},
+			{Name: "model", Type: "string", Description: "chat model, default gpt-3.5-turbo"},
+		}, clientInputs...),
+		Estimate: estimateDiagrams,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			plannerInputs := make(map[string]interface{}, len(inputs))
+			for key, value := range inputs {
+				if key != "generate_images" {
+					plannerInputs[key] = value
+				}
+			}
+			result, _, err := DiagramPlannerTool.ExecuteContext(ctx, plannerInputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			descriptions := []string{}
+			for _, figure := range result.(DocumentWithFigures).Figures {
+				descriptions = append(descriptions, figure.Description)
+			}
+			encoded, err := json.Marshal(descriptions)
+			if err != nil {
+				return nil, nil, err
+			}
+			return string(encoded), nil, nil
+		},
+	}
+)
+
+type paragraphSpan struct {
+	start, end int
+}
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+func splitParagraphs(text string) []paragraphSpan {
+	var spans []paragraphSpan
+	start := 0
+	for _, loc := range append(paragraphBreak.FindAllStringIndex(text, -1), []int{len(text), len(text)}) {
+		if strings.TrimSpace(text[start:loc[0]]) != "" {
+			spans = append(spans, paragraphSpan{start: start, end: loc[0]})
+		}
+		start = loc[1]
+	}
+	return spans
+}
+
+func parseDiagramPlan(reply string, paragraphs []paragraphSpan) ([]DiagramItem, error) {
+	var plan struct {
+		Diagrams []struct {
+			Title          string `json:"title"`
+			Description    string `json:"description"`
+			Type           string `json:"type"`
+			AfterParagraph int    `json:"after_paragraph"`
+		} `json:"diagrams"`
+	}
+	if err := json.Unmarshal([]byte(reply), &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode diagram plan: %v", err)
+	}
+
+	items := make([]DiagramItem, 0, len(plan.Diagrams))
+	for _, d := range plan.Diagrams {
+		if strings.TrimSpace(d.Description) == "" {
+			continue
+		}
+		paragraph := d.AfterParagraph
+		if paragraph < 0 {
+			paragraph = 0
+		}
+		if paragraph >= len(paragraphs) {
+			paragraph = len(paragraphs) - 1
+		}
+		items = append(items, DiagramItem{
+			Title:       d.Title,
+			Description: d.Description,
+			Type:        normalizeDiagramType(d.Type),
+			Paragraph:   paragraph,
+			Offset:      paragraphs[paragraph].end,
+		})
+	}
+	return items, nil
+}
+
+func normalizeDiagramType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	for _, known := range DiagramTypes {
+		if t == known {
+			return t
+		}
+	}
+	return "illustration"
+}
+
+func diagramPrompt(item DiagramItem) string {
+	return fmt.Sprintf("A clean, clearly labelled %s titled %q on a white background. %s", item.Type, item.Title, item.Description)
+}
@@ -0,0 +1,130 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const diagramContent = "Requests enter the gateway.\n\nThe gateway routes them to workers."
+
+const diagramPlan = `{"diagrams": [
+	{"title": "Flow", "description": "gateway to workers", "type": "Flowchart", "after_paragraph": 7},
+	{"title": "Empty", "description": " ", "type": "chart", "after_paragraph": 0}
+]}`
+
+func TestDiagramPlannerReturnsDocument(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{"/chat/completions": chatReply(diagramPlan)})
+
+	result, _, err := DiagramPlannerTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"content": diagramContent}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok := result.(DocumentWithFigures)
+	if !ok {
+		t.Fatalf("result is %T, want DocumentWithFigures", result)
+	}
+	if doc.Text != diagramContent {
+		t.Errorf("Text = %q", doc.Text)
+	}
+	if len(doc.Figures) != 1 {
+		t.Fatalf("got %d figures, want 1: %+v", len(doc.Figures), doc.Figures)
+	}
+	figure := doc.Figures[0]
+	if figure.Type != "flowchart" || figure.Paragraph != 1 || figure.Offset != len(diagramContent) {
+		t.Errorf("figure = %+v", figure)
+	}
+}
+
+func TestDiagramPlannerWithoutDiagrams(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{"/chat/completions": chatReply(`{"diagrams": []}`)})
+
+	result, _, err := DiagramPlannerTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"content": diagramContent}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := result.(DocumentWithFigures); !ok || len(doc.Figures) != 0 || doc.Markdown() != diagramContent {
+		t.Errorf("result = %#v, want the text without figures", result)
+	}
+}
+
+func TestDiagramPlannerGeneratesImages(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": chatReply(diagramPlan),
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			body := decodeTestJSON(t, r)
+			if prompt, _ := body["prompt"].(string); !strings.Contains(prompt, "gateway to workers") {
+				t.Errorf("prompt = %q", prompt)
+			}
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{{"url": "https://images.test/flow.png"}}})
+		},
+	})
+
+	result, _, err := DiagramPlannerTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"content":         diagramContent,
+		"generate_images": true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := result.(DocumentWithFigures)
+	want := diagramContent + "\n\n![Flow](https://images.test/flow.png)"
+	if got := doc.Markdown(); got != want {
+		t.Errorf("Markdown() = %q, want %q", got, want)
+	}
+}
+
+func TestDiagramPlannerKeepsUnstoredImagesInline(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": chatReply(diagramPlan),
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{{"b64_json": base64.StdEncoding.EncodeToString(testPNG(t))}}})
+		},
+	})
+
+	result, _, err := DiagramPlannerTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"content":         diagramContent,
+		"generate_images": true,
+		"response_format": "b64_json",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := result.(DocumentWithFigures)
+	if !strings.Contains(doc.Markdown(), "![Flow](data:image/png;base64,") {
+		t.Errorf("Markdown() = %q, want the figure as a data URL", doc.Markdown())
+	}
+}
+
+func TestImageNeedCheckerReturnsDescriptions(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{"/chat/completions": chatReply(diagramPlan)})
+
+	result, _, err := ImageNeedCheckerTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"content":         diagramContent,
+		"generate_images": true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != `["gateway to workers"]` {
+		t.Errorf("result = %#v", result)
+	}
+}
+
+func TestDocumentMarkdown(t *testing.T) {
+	doc := DocumentWithFigures{
+		Text: "one\n\ntwo",
+		Figures: []DiagramItem{
+			{Title: "B", ImagePath: "b.png", Offset: 8},
+			{Title: "A", ImageURL: "https://a", Offset: 3},
+			{Title: "Unplaced", ImagePath: "c.png", Offset: 99},
+			{Title: "Not generated", Offset: 3},
+		},
+	}
+	want := "one\n\n![A](https://a)\n\ntwo\n\n![B](b.png)"
+	if got := doc.Markdown(); got != want {
+		t.Errorf("Markdown() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package aicraft
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	WorkflowStarted   EventType = "workflow_started"
+	WorkflowFinished  EventType = "workflow_finished"
+	AgentStarted      EventType = "agent_started"
+	AgentFinished     EventType = "agent_finished"
+	AgentSkipped      EventType = "agent_skipped"
+	AgentIteration    EventType = "agent_iteration"
+	TaskStarted       EventType = "task_started"
+	TaskSucceeded     EventType = "task_succeeded"
+	TaskFailed        EventType = "task_failed"
+	TaskRetried       EventType = "task_retried"
+	TaskSkipped       EventType = "task_skipped"
+	ApprovalRequested EventType = "approval_requested"
+	StreamChunk       EventType = "stream_chunk"
+)
+
+// Event describes a step of a workflow run. Duration and Usage are set on
+// the finished, succeeded and failed events, Attempt on task events, where
+// it is the attempt, and on AgentIteration events, where it is the iteration
+// of a loop, and Chunk on StreamChunk events. Events of a sub-workflow carry the ID of
+// the run it belongs to and, in Parent, the ID of the task that runs it;
+// tasks of nested sub-workflows are joined with '/'.
+type Event struct {
+	Type     EventType
+	RunID    string
+	Parent   string
+	AgentID  string
+	TaskID   string
+	Time     time.Time
+	Duration time.Duration
+	Attempt  int
+	Chunk    string
+	Usage    TokenUsage
+	Err      error
+}
+
+// EventBus delivers events to hook functions and channel subscribers.
+type EventBus struct {
+	mu     sync.RWMutex
+	hooks  []func(Event)
+	subs   map[int]chan Event
+	nextID int
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]chan Event)}
+}
+
+// OnEvent registers a hook that is called synchronously for every event.
+// Hooks may register hooks and subscribers, but should return quickly as
+// they delay the task that published the event.
+func (b *EventBus) OnEvent(hook func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hooks = append(b.hooks, hook)
+}
+
+// Subscribe returns a channel receiving events and a function that ends the
+// subscription. Events are dropped for subscribers whose buffer is full.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, buffer)
+	b.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs, id)
+			close(ch)
+		})
+	}
+}
+
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	// Hooks run without the lock so that they can register hooks and
+	// subscribers and do not hold up other publishers.
+	b.mu.RLock()
+	hooks := b.hooks
+	b.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+
+	// Subscribers are sent to under the lock so that their channels are
+	// not closed meanwhile.
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,176 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// funcTool returns a tool that runs fn without streaming.
+func funcTool(id string, fn func(inputs map[string]interface{}) (interface{}, error)) *Tool {
+	return &Tool{ID: id, Name: id, ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		result, err := fn(inputs)
+		return result, nil, err
+	}}
+}
+
+// echoTool returns its 'value' input.
+var echoTool = funcTool("echo", func(inputs map[string]interface{}) (interface{}, error) {
+	return inputs["value"], nil
+})
+
+// newTestManager returns a manager with tools registered and config
+// defined.
+func newTestManager(t *testing.T, config WorkflowConfig, tools ...*Tool) *Manager {
+	t.Helper()
+	m := NewManager()
+	for _, tool := range tools {
+		if err := m.RegisterTool(tool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.InitializeWorkflow(config); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// eventRecorder collects the events of a manager.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func recordEvents(m *Manager) *eventRecorder {
+	recorder := &eventRecorder{}
+	m.Events.OnEvent(func(event Event) {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.events = append(recorder.events, event)
+	})
+	return recorder
+}
+
+// types returns the types of the recorded events of task taskID, or of all
+// events when taskID is empty.
+func (r *eventRecorder) types(taskID string) []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []EventType
+	for _, event := range r.events {
+		if taskID == "" || event.TaskID == taskID {
+			types = append(types, event.Type)
+		}
+	}
+	return types
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	bus := NewEventBus()
+	var hooked []EventType
+	bus.OnEvent(func(event Event) { hooked = append(hooked, event.Type) })
+	events, unsubscribe := bus.Subscribe(1)
+
+	bus.Publish(Event{Type: WorkflowStarted})
+	bus.Publish(Event{Type: WorkflowFinished})
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(Event{Type: WorkflowStarted})
+
+	var received []Event
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) != 1 || received[0].Type != WorkflowStarted || received[0].Time.IsZero() {
+		t.Errorf("subscriber got %+v, want the first event only", received)
+	}
+	if want := []EventType{WorkflowStarted, WorkflowFinished, WorkflowStarted}; !reflect.DeepEqual(hooked, want) {
+		t.Errorf("hook got %v, want %v", hooked, want)
+	}
+}
+
+func TestEventBusHooksCanSubscribe(t *testing.T) {
+	bus := NewEventBus()
+	var late []EventType
+	bus.OnEvent(func(event Event) {
+		if event.Type == WorkflowStarted {
+			bus.OnEvent(func(event Event) { late = append(late, event.Type) })
+			_, unsubscribe := bus.Subscribe(1)
+			unsubscribe()
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bus.Publish(Event{Type: WorkflowStarted})
+		bus.Publish(Event{Type: WorkflowFinished})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a hook registering a hook deadlocked Publish")
+	}
+	if !reflect.DeepEqual(late, []EventType{WorkflowFinished}) {
+		t.Errorf("the hook added during an event got %v, want the later events", late)
+	}
+}
+
+func TestRunEventsWithRetries(t *testing.T) {
+	failures := 1
+	flaky := funcTool("flaky", func(inputs map[string]interface{}) (interface{}, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("temporary")
+		}
+		return "ok", nil
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "flaky", Retries: 2}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}, flaky)
+	recorder := recordEvents(m)
+
+	run, err := m.NewRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EventType{WorkflowStarted, AgentStarted, TaskStarted, TaskRetried, TaskStarted, TaskSucceeded, AgentFinished, WorkflowFinished}
+	if got := recorder.types(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	for _, event := range recorder.events {
+		if event.RunID != run.ID {
+			t.Errorf("event %s has run ID %q, want %q", event.Type, event.RunID, run.ID)
+		}
+		if event.Type == TaskSucceeded && event.Attempt != 2 {
+			t.Errorf("TaskSucceeded has attempt %d, want 2", event.Attempt)
+		}
+	}
+}
+
+func TestRunEventsWhenRetriesRunOut(t *testing.T) {
+	broken := funcTool("broken", func(inputs map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("permanent")
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "broken", Retries: 1}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}, broken)
+	recorder := recordEvents(m)
+
+	if err := m.ExecuteWorkflow(); err == nil {
+		t.Fatal("want the task's error")
+	}
+	want := []EventType{TaskStarted, TaskRetried, TaskStarted, TaskFailed}
+	if got := recorder.types("t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("task events = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,521 @@
+package aicraft
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An expression is a condition over task results, such as
+//
+//	len(check_images) > 0 && classify.label != "spam"
+//
+// Identifiers name tasks, may contain '-' as in fetch-data.output, and
+// evaluate to their results, encoded as JSON would encode them, so struct
+// fields are accessed by their JSON names with '.' and list elements with
+// [i]. Strings are quoted with ' or ", and true, false and null are
+// literals. The operators are ||, &&, !, ==, !=, <, <=, >
+// and >=, and len(x) and contains(x, y) are available. In conditions, null,
+// false, 0, "" and empty lists and objects count as false.
+type expression struct {
+	source string
+	root   node
+}
+
+type node interface {
+	eval(lookup func(name string) (interface{}, error)) (interface{}, error)
+}
+
+func parseExpression(source string) (*expression, error) {
+	p := &exprParser{source: source}
+	if err := p.tokenize(); err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &expression{source: source, root: root}, nil
+}
+
+// names returns the identifiers the expression refers to.
+func (e *expression) names() []string {
+	var names []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case identNode:
+			names = append(names, string(n))
+		case fieldNode:
+			walk(n.target)
+		case indexNode:
+			walk(n.target)
+			walk(n.index)
+		case unaryNode:
+			walk(n.operand)
+		case binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+	return names
+}
+
+// evaluate returns whether the expression holds for the values of vars.
+func (e *expression) evaluate(vars map[string]interface{}) (bool, error) {
+	value, err := e.root.eval(func(name string) (interface{}, error) {
+		value, err := normalize(vars[name])
+		if err != nil {
+			return nil, fmt.Errorf("cannot use the result of %s: %v", name, err)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("expression %q: %v", e.source, err)
+	}
+	return truthy(value), nil
+}
+
+// normalize converts value to the types encoding/json decodes into.
+func normalize(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case nil, bool, float64, string:
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+type token struct {
+	kind string // "ident", "number", "string" or the operator itself
+	text string
+}
+
+type exprParser struct {
+	source string
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) tokenize() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '_' || unicode.IsLetter(c):
+			// There is no subtraction, so '-' can join task IDs such as
+			// fetch-data.
+			j := i + size
+			for j < len(s) {
+				c, size := utf8.DecodeRuneInString(s[j:])
+				if c != '_' && c != '-' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+					break
+				}
+				j += size
+			}
+			p.tokens = append(p.tokens, token{"ident", s[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{"number", s[i:j]})
+			i = j
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], s[i])
+			if j < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+			p.tokens = append(p.tokens, token{"string", s[i+1 : i+1+j]})
+			i += j + 2
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ",", ".", "[", "]"} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q", c)
+			}
+			p.tokens = append(p.tokens, token{op, op})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *exprParser) expect(kind string) (token, error) {
+	if p.peek() != kind {
+		if p.pos < len(p.tokens) {
+			return token{}, fmt.Errorf("expected %s, found %q", kind, p.tokens[p.pos].text)
+		}
+		return token{}, fmt.Errorf("expected %s at the end", kind)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *exprParser) parseOr() (node, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (node, error) {
+	return p.parseBinary([]string{"&&"}, p.parseComparison)
+}
+
+func (p *exprParser) parseComparison() (node, error) {
+	return p.parseBinary([]string{"==", "!=", "<=", ">=", "<", ">"}, p.parseUnary)
+}
+
+func (p *exprParser) parseBinary(ops []string, operand func() (node, error)) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range ops {
+			found = found || op == candidate
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (node, error) {
+	if p.peek() == "!" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.pos++
+			field, err := p.expect("ident")
+			if err != nil {
+				return nil, err
+			}
+			n = fieldNode{target: n, name: field.text}
+		case "[":
+			p.pos++
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = indexNode{target: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (node, error) {
+	switch p.peek() {
+	case "number":
+		t := p.tokens[p.pos]
+		p.pos++
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return literalNode{value}, nil
+	case "string":
+		p.pos++
+		return literalNode{p.tokens[p.pos-1].text}, nil
+	case "(":
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return n, err
+	case "ident":
+		name := p.tokens[p.pos].text
+		p.pos++
+		switch name {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		if p.peek() != "(" {
+			return identNode(name), nil
+		}
+		p.pos++
+		call := callNode{name: name}
+		for p.peek() != ")" {
+			if len(call.args) > 0 {
+				if _, err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.pos++
+		return call, call.check()
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	}
+	return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(func(string) (interface{}, error)) (interface{}, error) {
+	return n.value, nil
+}
+
+type identNode string
+
+func (n identNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	return lookup(string(n))
+}
+
+type fieldNode struct {
+	target node
+	name   string
+}
+
+func (n fieldNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	target, err := n.target.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	if object, ok := target.(map[string]interface{}); ok {
+		return object[n.name], nil
+	}
+	return nil, nil
+}
+
+type indexNode struct {
+	target, index node
+}
+
+func (n indexNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	target, err := n.target.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch target := target.(type) {
+	case []interface{}:
+		if i, ok := index.(float64); ok && i >= 0 && int(i) < len(target) {
+			return target[int(i)], nil
+		}
+	case map[string]interface{}:
+		if key, ok := index.(string); ok {
+			return target[key], nil
+		}
+	}
+	return nil, nil
+}
+
+type unaryNode struct{ operand node }
+
+func (n unaryNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	value, err := n.operand.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(value), nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n binaryNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	left, err := n.left.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+	}
+	right, err := n.right.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return truthy(right), nil
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	}
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return compare(n.op, l < r, l == r), nil
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return compare(n.op, l < r, l == r), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot compare %v %s %v", left, n.op, right)
+}
+
+func compare(op string, less, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n callNode) check() error {
+	arity := map[string]int{"len": 1, "contains": 2}
+	want, ok := arity[n.name]
+	if !ok {
+		return fmt.Errorf("unknown function %s", n.name)
+	}
+	if len(n.args) != want {
+		return fmt.Errorf("%s takes %d arguments", n.name, want)
+	}
+	return nil
+}
+
+func (n callNode) eval(lookup func(string) (interface{}, error)) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(lookup)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	switch n.name {
+	case "len":
+		switch v := args[0].(type) {
+		case nil:
+			return float64(0), nil
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len of %v", args[0])
+	default: // contains
+		switch v := args[0].(type) {
+		case string:
+			s, ok := args[1].(string)
+			return ok && strings.Contains(v, s), nil
+		case []interface{}:
+			for _, item := range v {
+				if reflect.DeepEqual(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := args[1].(string)
+			_, found := v[key]
+			return ok && found, nil
+		}
+		return false, nil
+	}
+}
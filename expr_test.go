@@ -0,0 +1,110 @@
+package aicraft
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	vars := map[string]interface{}{
+		"images":     []DiagramItem{{Title: "chart", Paragraph: 2}},
+		"none":       []string{},
+		"label":      "spam",
+		"score":      0.75,
+		"count":      3,
+		"ok":         true,
+		"document":   DocumentWithFigures{Text: "body"},
+		"tags":       map[string]interface{}{"lang": "en"},
+		"fetch-data": map[string]interface{}{"output": "x"},
+		"größe":      2,
+	}
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{`len(images) > 0`, true},
+		{`len(none) > 0`, false},
+		{`none`, false},
+		{`images[0].title == "chart"`, true},
+		{`images[0].paragraph >= 2 && images[0].paragraph < 3`, true},
+		{`images[5].title == null`, true},
+		{`label != 'spam' || score > 0.5`, true},
+		{`!(label == "spam")`, false},
+		{`count == 3 && ok`, true},
+		{`count <= 2`, false},
+		{`"apple" < "banana"`, true},
+		{`document.text`, true},
+		{`len(document.figures) == 0`, true},
+		{`missing`, false},
+		{`missing.field == null`, true},
+		{`contains(label, "pa")`, true},
+		{`contains(none, "x")`, false},
+		{`contains(tags, "lang") && tags["lang"] == "en"`, true},
+		{`len(label) == 4`, true},
+		{`fetch-data.output == 'x'`, true},
+		{`größe == 2`, true},
+		{`false || null || 0 || ""`, false},
+	}
+	for _, test := range tests {
+		expr, err := parseExpression(test.source)
+		if err != nil {
+			t.Errorf("parseExpression(%q) = %v", test.source, err)
+			continue
+		}
+		got, err := expr.evaluate(vars)
+		if err != nil || got != test.want {
+			t.Errorf("%s = %v, %v, want %v", test.source, got, err, test.want)
+		}
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	vars := map[string]interface{}{"label": "spam", "score": 1.0, "bad": make(chan int)}
+	for _, source := range []string{`label < score`, `len(score) > 0`, `bad == null`} {
+		expr, err := parseExpression(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := expr.evaluate(vars); err == nil {
+			t.Errorf("%s evaluated without an error", source)
+		}
+	}
+	// The right side of && and || is not evaluated when the left decides.
+	expr, _ := parseExpression(`score > 2 && label < score`)
+	if ok, err := expr.evaluate(vars); ok || err != nil {
+		t.Errorf("short-circuit = %v, %v", ok, err)
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := map[string]string{
+		`len(a) >`:         "unexpected end",
+		`a == "unclosed`:   "unterminated string",
+		`a = b`:            "unexpected",
+		`a b`:              `unexpected "b"`,
+		`size(a) > 0`:      "unknown function size",
+		`contains(a) == 1`: "contains takes 2 arguments",
+		`(a || b`:          "",
+		`a[0`:              "",
+		`1.2.3 > 0`:        "",
+		`a # comment`:      "",
+		`a → b`:            `unexpected '→'`,
+	}
+	for source, want := range tests {
+		_, err := parseExpression(source)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseExpression(%q) = %v, want an error containing %q", source, err, want)
+		}
+	}
+}
+
+func TestExpressionNames(t *testing.T) {
+	expr, err := parseExpression(`len(a.items) > b[c] && !contains(d-1, "e")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := expr.names(); !reflect.DeepEqual(names, []string{"a", "b", "c", "d-1"}) {
+		t.Errorf("names() = %v", names)
+	}
+}
@@ -0,0 +1,388 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+type GeneratedImage struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	Path          string `json:"path,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// dataURL returns the image data as a data URL, or "" when it has none.
+func (img GeneratedImage) dataURL() string {
+	if img.B64JSON == "" {
+		return ""
+	}
+	contentType := img.ContentType
+	if contentType == "" {
+		contentType = "image/png"
+	}
+	return "data:" + contentType + ";base64," + img.B64JSON
+}
+
+// ImageSink persists generated images, e.g. to a directory or a blob store,
+// and returns the location they were written to.
+type ImageSink interface {
+	Store(name string, data []byte, contentType string) (string, error)
+}
+
+type DirSink struct {
+	Dir string
+}
+
+func (s DirSink) Store(name string, data []byte, contentType string) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %w", err)
+	}
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write image: %w", err)
+	}
+	return path, nil
+}
+
+type imagesResponse struct {
+	Data []struct {
+		URL           string `json:"url"`
+		B64JSON       string `json:"b64_json"`
+		RevisedPrompt string `json:"revised_prompt"`
+	} `json:"data"`
+}
+
+var (
+	ImageGeneratorTool = &Tool{
+		ID:   "image_generator",
+		Name: "Image Generator",
+		Inputs: append([]ToolInput{
+			{Name: "description", Type: "string", Required: true, Description: "image prompt"},
+			{Name: "model", Type: "string", Description: "image model"},
+			{Name: "n", Type: "int", Description: "number of images"},
+			{Name: "size", Type: "string", Description: "image size, default 1024x1024"},
+			{Name: "quality", Type: "string", Description: "image quality"},
+			{Name: "style", Type: "string", Description: "image style"},
+			{Name: "response_format", Type: "string", Description: "url or b64_json"},
+			{Name: "output_dir", Type: "string", Description: "directory to store the images in"},
+			{Name: "image_sink", Type: "ImageSink", Description: "sink to store the images in"},
+		}, clientInputs...),
+		Estimate: estimateImages,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			description, ok := inputs["description"].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("input 'description' is required and must be a string")
+			}
+
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+
+			n := 1
+			if v, ok := inputs["n"].(int); ok && v > 0 {
+				n = v
+			}
+			data := map[string]interface{}{
+				"prompt": description,
+				"n":      n,
+				"size":   "1024x1024",
+			}
+			for _, key := range []string{"model", "size", "quality", "style", "response_format"} {
+				if v, ok := inputs[key].(string); ok && v != "" {
+					data[key] = v
+				}
+			}
+
+			if verbose {
+				log.Printf("Generating image with description: %s", description)
+			}
+
+			var response imagesResponse
+			if err := client.postJSON(ctx, "/images/generations", data, &response); err != nil {
+				return nil, nil, err
+			}
+
+			images, err := collectImages(ctx, client, response, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if verbose {
+				for _, image := range images {
+					log.Printf("Generated image: url=%s path=%s", image.URL, image.Path)
+				}
+			}
+
+			// A single image that is neither stored nor returned as data is
+			// returned as its URL, as before the tool took options.
+			if n == 1 && imageSinkFromInputs(inputs) == nil && len(images) == 1 && images[0].URL != "" && images[0].B64JSON == "" {
+				return images[0].URL, nil, nil
+			}
+			return images, nil, nil
+		},
+	}
+
+	ImageEditorTool = &Tool{
+		ID:   "image_editor",
+		Name: "Image Editor",
+		Inputs: append([]ToolInput{
+			{Name: "image", Type: "image", Required: true, Description: "image path or generated image to edit"},
+			{Name: "prompt", Type: "string", Required: true, Description: "description of the edit"},
+			{Name: "mask", Type: "image", Description: "mask of the area to edit"},
+			{Name: "model", Type: "string", Description: "image model"},
+			{Name: "n", Type: "int", Description: "number of images"},
+			{Name: "size", Type: "string", Description: "image size"},
+			{Name: "response_format", Type: "string", Description: "url or b64_json"},
+			{Name: "output_dir", Type: "string", Description: "directory to store the images in"},
+			{Name: "image_sink", Type: "ImageSink", Description: "sink to store the images in"},
+		}, clientInputs...),
+		Estimate: estimateImages,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			prompt, ok := inputs["prompt"].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("input 'prompt' is required and must be a string")
+			}
+
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+
+			image, err := imageFileFromInput(ctx, client, "image", inputs["image"])
+			if err != nil {
+				return nil, nil, err
+			}
+			files := []multipartFile{image}
+			if mask, ok := inputs["mask"]; ok && mask != nil {
+				file, err := imageFileFromInput(ctx, client, "mask", mask)
+				if err != nil {
+					return nil, nil, err
+				}
+				files = append(files, file)
+			}
+
+			fields := imageFormFields(inputs, "model", "size", "response_format")
+			fields.Set("prompt", prompt)
+
+			if verbose {
+				log.Printf("Editing image %s with prompt: %s", image.Name, prompt)
+			}
+
+			var response imagesResponse
+			if err := client.postMultipart(ctx, "/images/edits", fields, files, &response); err != nil {
+				return nil, nil, err
+			}
+
+			images, err := collectImages(ctx, client, response, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			return images, nil, nil
+		},
+	}
+
+	ImageVariationTool = &Tool{
+		ID:   "image_variation",
+		Name: "Image Variation",
+		Inputs: append([]ToolInput{
+			{Name: "image", Type: "image", Required: true, Description: "image path or generated image to vary"},
+			{Name: "model", Type: "string", Description: "image model"},
+			{Name: "n", Type: "int", Description: "number of images"},
+			{Name: "size", Type: "string", Description: "image size"},
+			{Name: "response_format", Type: "string", Description: "url or b64_json"},
+			{Name: "output_dir", Type: "string", Description: "directory to store the images in"},
+			{Name: "image_sink", Type: "ImageSink", Description: "sink to store the images in"},
+		}, clientInputs...),
+		Estimate: estimateImages,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			verbose, _ := inputs["verbose"].(bool)
+
+			image, err := imageFileFromInput(ctx, client, "image", inputs["image"])
+			if err != nil {
+				return nil, nil, err
+			}
+
+			fields := imageFormFields(inputs, "model", "size", "response_format")
+
+			if verbose {
+				log.Printf("Creating variations of image %s", image.Name)
+			}
+
+			var response imagesResponse
+			if err := client.postMultipart(ctx, "/images/variations", fields, []multipartFile{image}, &response); err != nil {
+				return nil, nil, err
+			}
+
+			images, err := collectImages(ctx, client, response, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			return images, nil, nil
+		},
+	}
+)
+
+func imageFormFields(inputs map[string]interface{}, keys ...string) url.Values {
+	fields := url.Values{}
+	if n, ok := inputs["n"].(int); ok && n > 0 {
+		fields.Set("n", strconv.Itoa(n))
+	}
+	for _, key := range keys {
+		if v, ok := inputs[key].(string); ok && v != "" {
+			fields.Set(key, v)
+		}
+	}
+	return fields
+}
+
+// imageFileFromInput loads an image input given as a local path, an http(s)
+// URL, a GeneratedImage or the output of another image tool.
+func imageFileFromInput(ctx context.Context, client *Client, field string, value interface{}) (multipartFile, error) {
+	var image GeneratedImage
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			image.URL = v
+		} else {
+			image.Path = v
+		}
+	case GeneratedImage:
+		image = v
+	case []GeneratedImage:
+		if len(v) == 0 {
+			return multipartFile{}, fmt.Errorf("input '%s' contains no images", field)
+		}
+		image = v[0]
+	default:
+		return multipartFile{}, fmt.Errorf("input '%s' is required and must be a file path or a GeneratedImage", field)
+	}
+
+	var data []byte
+	var err error
+	name := field + ".png"
+	switch {
+	case image.Path != "":
+		data, err = os.ReadFile(image.Path)
+		if err != nil {
+			return multipartFile{}, fmt.Errorf("failed to read input '%s': %v", field, err)
+		}
+		name = filepath.Base(image.Path)
+	case image.B64JSON != "":
+		data, err = base64.StdEncoding.DecodeString(image.B64JSON)
+		if err != nil {
+			return multipartFile{}, fmt.Errorf("failed to decode input '%s': %v", field, err)
+		}
+	case image.URL != "":
+		data, err = client.download(ctx, image.URL)
+		if err != nil {
+			return multipartFile{}, err
+		}
+	default:
+		return multipartFile{}, fmt.Errorf("input '%s' has no path, data or URL", field)
+	}
+
+	return multipartFile{Field: field, Name: name, ContentType: mimetype.Detect(data).String(), Data: data}, nil
+}
+
+// imageSinkFromInputs returns the sink configured through the 'image_sink' or
+// 'output_dir' inputs, or nil when images should not be stored.
+func imageSinkFromInputs(inputs map[string]interface{}) ImageSink {
+	if sink, ok := inputs["image_sink"].(ImageSink); ok && sink != nil {
+		return sink
+	}
+	if dir, ok := inputs["output_dir"].(string); ok && dir != "" {
+		return DirSink{Dir: dir}
+	}
+	return nil
+}
+
+func collectImages(ctx context.Context, client *Client, response imagesResponse, inputs map[string]interface{}) ([]GeneratedImage, error) {
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("no images returned from OpenAI API")
+	}
+
+	sink := imageSinkFromInputs(inputs)
+	images := make([]GeneratedImage, 0, len(response.Data))
+	for i, d := range response.Data {
+		image := GeneratedImage{URL: d.URL, B64JSON: d.B64JSON, RevisedPrompt: d.RevisedPrompt}
+
+		var raw []byte
+		var err error
+		switch {
+		case d.B64JSON != "":
+			raw, err = base64.StdEncoding.DecodeString(d.B64JSON)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode image %d: %v", i, err)
+			}
+		case sink != nil && d.URL != "":
+			raw, err = client.download(ctx, d.URL)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if raw != nil {
+			mtype := mimetype.Detect(raw)
+			image.ContentType = mtype.String()
+			image.Size = int64(len(raw))
+			if sink != nil {
+				name := fmt.Sprintf("image-%d-%d%s", time.Now().UnixNano(), i, mtype.Extension())
+				image.Path, err = sink.Store(name, raw, image.ContentType)
+				if err != nil {
+					return nil, err
+				}
+				image.B64JSON = ""
+			}
+		}
+
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (c *Client) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: status code %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	return data, nil
+}
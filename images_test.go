@@ -0,0 +1,221 @@
+package aicraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPNG returns a small PNG image.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageGeneratorStoresImages(t *testing.T) {
+	data := testPNG(t)
+	var request map[string]interface{}
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			request = decodeTestJSON(t, r)
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{
+				{"b64_json": base64.StdEncoding.EncodeToString(data), "revised_prompt": "a red square"},
+			}})
+		},
+	})
+	dir := t.TempDir()
+
+	result, _, err := ImageGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"description":     "a square",
+		"model":           "dall-e-3",
+		"n":               1,
+		"quality":         "hd",
+		"response_format": "b64_json",
+		"output_dir":      dir,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request["model"] != "dall-e-3" || request["quality"] != "hd" || request["response_format"] != "b64_json" {
+		t.Errorf("request = %v", request)
+	}
+	images := result.([]GeneratedImage)
+	if len(images) != 1 {
+		t.Fatalf("got %d images, want 1", len(images))
+	}
+	img := images[0]
+	if img.ContentType != "image/png" || img.Size != int64(len(data)) || img.RevisedPrompt != "a red square" || img.B64JSON != "" {
+		t.Errorf("image = %+v", img)
+	}
+	stored, err := os.ReadFile(img.Path)
+	if err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("stored image at %q does not match: %v", img.Path, err)
+	}
+}
+
+func TestImageGeneratorDownloadsURLsForSinks(t *testing.T) {
+	data := testPNG(t)
+	var srvURL string
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{{"url": srvURL + "/files/image.png"}}})
+		},
+		"/files/image.png": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+	})
+	srvURL = srv.URL
+	sink := &memorySink{}
+
+	result, _, err := ImageGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"description": "a square",
+		"image_sink":  sink,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := result.([]GeneratedImage)[0]
+	if img.URL != srvURL+"/files/image.png" || img.Path != "memory/0" || !bytes.Equal(sink.images[0], data) {
+		t.Errorf("image = %+v", img)
+	}
+}
+
+func TestImageGeneratorWithoutImages(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, map[string]interface{}{"data": []interface{}{}})
+		},
+	})
+	if _, _, err := ImageGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"description": "x"})); err == nil {
+		t.Error("want an error when no images are returned")
+	}
+}
+
+func TestImageGeneratorReturnsSingleURL(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/images/generations": func(w http.ResponseWriter, r *http.Request) {
+			n, _ := decodeTestJSON(t, r)["n"].(float64)
+			var data []map[string]string
+			for i := 0; i < int(n); i++ {
+				data = append(data, map[string]string{"url": fmt.Sprintf("https://images.test/%d", i)})
+			}
+			writeTestJSON(w, map[string]interface{}{"data": data})
+		},
+	})
+
+	result, _, err := ImageGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"description": "x"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "https://images.test/0" {
+		t.Errorf("result = %#v, want the URL as a string", result)
+	}
+
+	result, _, err = ImageGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"description": "x", "n": 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images, ok := result.([]GeneratedImage); !ok || len(images) != 2 {
+		t.Errorf("result = %#v, want both images", result)
+	}
+}
+
+type memorySink struct {
+	images [][]byte
+}
+
+func (s *memorySink) Store(name string, data []byte, contentType string) (string, error) {
+	s.images = append(s.images, data)
+	return fmt.Sprintf("memory/%d", len(s.images)-1), nil
+}
+
+func TestImageEditorSendsImageAndMask(t *testing.T) {
+	data := testPNG(t)
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(imagePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/images/edits": func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Fatal(err)
+			}
+			if r.FormValue("prompt") != "add a hat" || r.FormValue("n") != "2" || r.FormValue("size") != "512x512" {
+				t.Errorf("form = %v", r.MultipartForm.Value)
+			}
+			image, header, err := r.FormFile("image")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer image.Close()
+			if header.Filename != "photo.png" || header.Header.Get("Content-Type") != "image/png" {
+				t.Errorf("image part = %q %v", header.Filename, header.Header)
+			}
+			if _, _, err := r.FormFile("mask"); err != nil {
+				t.Errorf("mask: %v", err)
+			}
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{{"url": "https://images.test/1"}, {"url": "https://images.test/2"}}})
+		},
+	})
+
+	result, _, err := ImageEditorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"image":  imagePath,
+		"mask":   GeneratedImage{B64JSON: base64.StdEncoding.EncodeToString(data)},
+		"prompt": "add a hat",
+		"n":      2,
+		"size":   "512x512",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images := result.([]GeneratedImage); len(images) != 2 || images[1].URL != "https://images.test/2" {
+		t.Errorf("images = %+v", images)
+	}
+}
+
+func TestImageVariationTakesGeneratedImages(t *testing.T) {
+	data := testPNG(t)
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/source.png": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+		"/images/variations": func(w http.ResponseWriter, r *http.Request) {
+			image, _, err := r.FormFile("image")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer image.Close()
+			got, _ := io.ReadAll(image)
+			if !bytes.Equal(got, data) {
+				t.Error("the variation request does not carry the downloaded image")
+			}
+			writeTestJSON(w, map[string]interface{}{"data": []map[string]string{{"url": "https://images.test/variant"}}})
+		},
+	})
+	for _, image := range []interface{}{[]GeneratedImage{{URL: srv.URL + "/source.png"}}, srv.URL + "/source.png"} {
+		_, _, err := ImageVariationTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{"image": image}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, err := ImageVariationTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"image": []GeneratedImage{},
+	}))
+	if err == nil {
+		t.Error("want an error for an empty image list")
+	}
+}
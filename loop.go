@@ -0,0 +1,44 @@
+package aicraft
+
+import "fmt"
+
+// checkLoop checks that a loop agent has a maximum number of iterations and
+// that its Until expression only refers to tasks for which task returns
+// true.
+func checkLoop(agent AgentConfig, task func(id string) bool) error {
+	if agent.Until == "" {
+		return nil
+	}
+	if agent.MaxIterations < 1 {
+		return fmt.Errorf("agent %s loops until %s without max_iterations", agent.ID, agent.Until)
+	}
+	return checkCondition(agent.ID, agent.Until, task)
+}
+
+// loopDone reports whether the agent is done after running its tasks for
+// the given iteration. For a loop, it records the iteration's outputs and
+// publishes an AgentIteration event; the loop ends once Until holds or after
+// MaxIterations, whichever comes first.
+func (r *Run) loopDone(agent *Agent, iteration int) (bool, error) {
+	if agent.Until == "" && agent.MaxIterations <= 1 {
+		return true, nil
+	}
+	output := make(map[string]interface{}, len(agent.Output))
+	for id, result := range agent.Output {
+		output[id] = result
+	}
+	agent.Iterations = append(agent.Iterations, output)
+	r.publish(Event{Type: AgentIteration, AgentID: agent.ID, Attempt: iteration})
+
+	if agent.Until != "" {
+		until, err := parseExpression(agent.Until)
+		if err != nil {
+			return true, err
+		}
+		done, err := until.evaluate(r.taskResults(agent))
+		if done || err != nil {
+			return true, err
+		}
+	}
+	return iteration >= agent.MaxIterations, nil
+}
@@ -0,0 +1,179 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// refineConfig loops a draft and a critique until the critic approves, at
+// most maxIterations times. The draft takes the previous critique as its
+// 'feedback' input.
+func refineConfig(maxIterations int) WorkflowConfig {
+	return WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "draft", ToolID: "draft", InputsFrom: map[string]string{"feedback": "critique"}},
+			{ID: "critique", ToolID: "critic", InputsFrom: map[string]string{"draft": "draft"}},
+		},
+		Agents: []AgentConfig{{
+			ID:            "writer",
+			Tasks:         []string{"draft", "critique"},
+			Until:         `critique.approved`,
+			MaxIterations: maxIterations,
+		}},
+	}
+}
+
+// refineTools returns a writer that revises its draft on feedback and a
+// critic that approves after the given number of revisions. Versions are
+// float64, as they are once restored from a checkpoint.
+func refineTools(revisions int) (*Tool, *Tool) {
+	draft := funcTool("draft", func(inputs map[string]interface{}) (interface{}, error) {
+		feedback, _ := inputs["feedback"].(map[string]interface{})
+		version, _ := feedback["version"].(float64)
+		return fmt.Sprintf("draft %d", int(version)+1), nil
+	})
+	critic := funcTool("critic", func(inputs map[string]interface{}) (interface{}, error) {
+		var version int
+		fmt.Sscanf(inputs["draft"].(string), "draft %d", &version)
+		return map[string]interface{}{"version": float64(version), "approved": version > revisions}, nil
+	})
+	return draft, critic
+}
+
+func TestLoopUntilApproved(t *testing.T) {
+	draft, critic := refineTools(2)
+	m := newTestManager(t, refineConfig(5), draft, critic)
+	recorder := recordEvents(m)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writer := run.Agents["writer"]
+	if len(writer.Iterations) != 3 {
+		t.Fatalf("got %d iterations, want 3", len(writer.Iterations))
+	}
+	for i, output := range writer.Iterations {
+		if want := fmt.Sprintf("draft %d", i+1); output["draft"] != want {
+			t.Errorf("iteration %d drafted %v, want %s", i+1, output["draft"], want)
+		}
+	}
+	if result := run.Tasks["draft"].Result; result != "draft 3" {
+		t.Errorf("final draft = %v", result)
+	}
+	iterations := 0
+	for _, event := range recorder.events {
+		if event.Type == AgentIteration {
+			iterations++
+			if event.Attempt != iterations {
+				t.Errorf("AgentIteration %d has attempt %d", iterations, event.Attempt)
+			}
+		}
+	}
+	if iterations != 3 {
+		t.Errorf("got %d AgentIteration events, want 3", iterations)
+	}
+}
+
+func TestLoopStopsAtMaxIterations(t *testing.T) {
+	draft, critic := refineTools(10)
+	m := newTestManager(t, refineConfig(2), draft, critic)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(run.Agents["writer"].Iterations); n != 2 {
+		t.Errorf("got %d iterations, want the maximum of 2", n)
+	}
+	if result := run.Tasks["draft"].Result; result != "draft 2" {
+		t.Errorf("final draft = %v", result)
+	}
+}
+
+func TestResumeLoopInItsIteration(t *testing.T) {
+	draft, critic := refineTools(2)
+	var feedback []interface{}
+	failing := funcTool("draft", func(inputs map[string]interface{}) (interface{}, error) {
+		feedback = append(feedback, inputs["feedback"])
+		if len(feedback) == 3 {
+			return nil, errors.New("provider unavailable")
+		}
+		result, _, err := draft.ExecuteContext(context.Background(), inputs)
+		return result, err
+	})
+	m := newTestManager(t, refineConfig(5), failing, critic)
+	m.Store = NewMemoryStore()
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err == nil {
+		t.Fatal("want the third draft to fail")
+	}
+	state, _ := m.Store.Load(run.ID)
+	if state.Iterations["writer"] != 3 || state.Tasks["critique"].Iteration != 2 {
+		t.Fatalf("checkpoint has iterations %v and critique %+v", state.Iterations, state.Tasks["critique"])
+	}
+
+	resumed, err := m.ResumeRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := recordEvents(m)
+	if err := resumed.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := feedback[len(feedback)-1].(map[string]interface{}); len(feedback) != 4 || last["version"] != 2.0 {
+		t.Errorf("drafts got feedback %v, want the resumed one to get the second critique", feedback)
+	}
+	if result := resumed.Tasks["draft"].Result; result != "draft 3" {
+		t.Errorf("final draft = %v", result)
+	}
+	var iterations []int
+	for _, event := range recorder.events {
+		if event.Type == AgentIteration {
+			iterations = append(iterations, event.Attempt)
+		}
+	}
+	if len(iterations) != 1 || iterations[0] != 3 {
+		t.Errorf("resumed run has iterations %v, want it to continue with the third", iterations)
+	}
+}
+
+func TestRepeatWithoutUntil(t *testing.T) {
+	calls := 0
+	count := funcTool("count", func(inputs map[string]interface{}) (interface{}, error) {
+		calls++
+		return calls, nil
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "count"}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}, MaxIterations: 3}},
+	}, count)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(run.Agents["a"].Iterations) != 3 {
+		t.Errorf("ran %d times with %d iterations, want 3", calls, len(run.Agents["a"].Iterations))
+	}
+}
+
+func TestLoopValidation(t *testing.T) {
+	draft, critic := refineTools(0)
+	m := newTestManager(t, WorkflowConfig{}, draft, critic)
+	config := refineConfig(0)
+	err := m.InitializeWorkflow(config)
+	if err == nil || !strings.Contains(err.Error(), "without max_iterations") {
+		t.Errorf("InitializeWorkflow() = %v, want max_iterations required", err)
+	}
+
+	config = refineConfig(3)
+	config.Agents[0].Until = "critique.approved =="
+	if err := m.InitializeWorkflow(config); err == nil || !strings.Contains(err.Error(), "invalid expression") {
+		t.Errorf("InitializeWorkflow() = %v, want the until expression rejected", err)
+	}
+}
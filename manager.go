@@ -1,34 +1,75 @@
 package aicraft
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WorkflowConfig struct {
-	Tasks  []TaskConfig
-	Agents []AgentConfig
+	Tasks  []TaskConfig  `yaml:"tasks" json:"tasks"`
+	Agents []AgentConfig `yaml:"agents" json:"agents"`
+	// Inputs and Outputs are the interface of a workflow registered as a
+	// sub-workflow with RegisterWorkflow. Outputs maps output names to the
+	// IDs of the tasks whose results they are.
+	Inputs  []WorkflowInput   `yaml:"inputs" json:"inputs,omitempty"`
+	Outputs map[string]string `yaml:"outputs" json:"outputs,omitempty"`
 }
 type TaskConfig struct {
-	ID     string
-	Name   string
-	ToolID string
-	Inputs map[string]interface{}
+	ID          string                 `yaml:"id" json:"id"`
+	Name        string                 `yaml:"name" json:"name"`
+	ToolID      string                 `yaml:"tool" json:"tool"`
+	Inputs      map[string]interface{} `yaml:"inputs" json:"inputs,omitempty"`
+	InputsFrom  map[string]string      `yaml:"inputs_from" json:"inputs_from,omitempty"`
+	Retries     int                    `yaml:"retries" json:"retries,omitempty"`
+	RetryDelay  time.Duration          `yaml:"retry_delay" json:"retry_delay,omitempty"`
+	BypassCache bool                   `yaml:"bypass_cache" json:"bypass_cache,omitempty"`
+	ForEach     string                 `yaml:"for_each" json:"for_each,omitempty"`
+	Parallelism int                    `yaml:"parallelism" json:"parallelism,omitempty"`
 }
 
 type AgentConfig struct {
-	ID        string
-	Name      string
-	DependsOn []string
-	Tasks     []string
+	ID        string   `yaml:"id" json:"id"`
+	Name      string   `yaml:"name" json:"name"`
+	DependsOn []string `yaml:"depends_on" json:"depends_on,omitempty"`
+	Tasks     []string `yaml:"tasks" json:"tasks"`
+	// Condition must hold for the agent to run; see Agent.Condition.
+	Condition string `yaml:"condition" json:"condition,omitempty"`
+	// Until and MaxIterations make the agent a loop; see Agent.Until.
+	Until         string `yaml:"until" json:"until,omitempty"`
+	MaxIterations int    `yaml:"max_iterations" json:"max_iterations,omitempty"`
 }
 
 type Manager struct {
 	Agents map[string]*Agent
 	Tasks  map[string]*Task
 	Tools  map[string]*Tool
-	mu     sync.Mutex
+	Events *EventBus
+	// TracerProvider receives the spans of workflow runs and the provider
+	// requests made by their tools. The global provider is used when nil.
+	TracerProvider trace.TracerProvider
+	// Client is shared by the tools of every run, so its Limiter and Cache
+	// apply to all of them. Task inputs 'api_key' and 'base_url' override its key
+	// and URL.
+	Client *Client
+	// Prices is used to compute the cost of runs. DefaultPrices is used
+	// when nil.
+	Prices PriceTable
+	// Budget limits the tokens and cost of each run. A run that exceeds it
+	// is cancelled and fails with ErrBudgetExceeded.
+	Budget Budget
+	// Store, when set, receives a checkpoint of every run so that failed
+	// runs can be continued with Resume.
+	Store     RunStore
+	usage     UsageReport
+	approvals map[string]*pendingApproval
+	mu        sync.Mutex
 }
 
 func NewManager() *Manager {
@@ -36,6 +77,13 @@ func NewManager() *Manager {
 		Agents: make(map[string]*Agent),
 		Tasks:  make(map[string]*Task),
 		Tools:  make(map[string]*Tool),
+		Events: NewEventBus(),
+		Client: &Client{
+			BaseURL:    defaultBaseURL,
+			HTTPClient: &http.Client{},
+			Limiter:    NewRateLimiter(0),
+			Cache:      NewMemoryCache(1000, 24*time.Hour),
+		},
 	}
 	m.initializePredefinedTools()
 	return m
@@ -44,148 +92,343 @@ func NewManager() *Manager {
 func (m *Manager) initializePredefinedTools() {
 	m.Tools[TextToPDFTool.ID] = TextToPDFTool
 	m.Tools[ImageGeneratorTool.ID] = ImageGeneratorTool
+	m.Tools[ImageEditorTool.ID] = ImageEditorTool
+	m.Tools[ImageVariationTool.ID] = ImageVariationTool
 	m.Tools[PDFToEmbeddingsTool.ID] = PDFToEmbeddingsTool
 	m.Tools[OpenAIContentGeneratorTool.ID] = OpenAIContentGeneratorTool
 	m.Tools[QueryToEmbeddingTool.ID] = QueryToEmbeddingTool
 	m.Tools[PDFExtractorTool.ID] = PDFExtractorTool
+	m.Tools[PDFPageImagesTool.ID] = PDFPageImagesTool
+	m.Tools[PDFEmbeddedImagesTool.ID] = PDFEmbeddedImagesTool
+	m.Tools[DiagramPlannerTool.ID] = DiagramPlannerTool
+	m.Tools[TranscriptionTool.ID] = TranscriptionTool
+	m.Tools[SpeechTool.ID] = SpeechTool
+	m.Tools[RouterTool.ID] = RouterTool
+	m.Tools[ApprovalTool.ID] = ApprovalTool
 }
 
-func (m *Manager) CreateAgent(id, name string, dependsOn []string) *Agent {
-	agent := NewAgent(id, name, dependsOn)
-	m.Agents[id] = agent
-	return agent
+// Errors returned when defining workflows. They are wrapped with the IDs
+// involved and can be checked with errors.Is.
+var (
+	ErrToolNotFound  = errors.New("unknown tool")
+	ErrTaskNotFound  = errors.New("unknown task")
+	ErrAgentNotFound = errors.New("unknown agent")
+	ErrDuplicateID   = errors.New("duplicate ID")
+)
+
+// RegisterTool makes tool available to tasks by its ID.
+func (m *Manager) RegisterTool(tool *Tool) error {
+	if tool == nil || tool.ID == "" || (tool.Execute == nil && tool.ExecuteContext == nil) {
+		return errors.New("a tool needs an ID and an Execute or ExecuteContext function")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Tools[tool.ID]; ok {
+		return fmt.Errorf("tool %s: %w", tool.ID, ErrDuplicateID)
+	}
+	m.Tools[tool.ID] = tool
+	return nil
+}
+
+// UnregisterTool removes the tool with id. Tasks that were already defined
+// keep using it.
+func (m *Manager) UnregisterTool(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Tools[id]; !ok {
+		return fmt.Errorf("%w %s", ErrToolNotFound, id)
+	}
+	delete(m.Tools, id)
+	return nil
+}
+
+func (m *Manager) CreateAgent(id, name string, dependsOn []string) (*Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Agents[id]; ok {
+		return nil, fmt.Errorf("agent %s: %w", id, ErrDuplicateID)
+	}
+	return m.createAgent(id, name, dependsOn), nil
 }
 
-func (m *Manager) CreateTask(id, name string, toolID string, inputs map[string]interface{}) *Task {
+func (m *Manager) CreateTask(id, name string, toolID string, inputs map[string]interface{}) (*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Tasks[id]; ok {
+		return nil, fmt.Errorf("task %s: %w", id, ErrDuplicateID)
+	}
 	tool, ok := m.Tools[toolID]
 	if !ok {
-		log.Fatalf("Error creating task %s: tool with ID %s not found", name, toolID)
-		return nil
+		return nil, fmt.Errorf("task %s uses %w %s", id, ErrToolNotFound, toolID)
 	}
+	return m.createTask(id, name, tool, inputs), nil
+}
+
+func (m *Manager) AssignTaskToAgent(agentID, taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Agents[agentID]; !ok {
+		return fmt.Errorf("cannot assign task %s to %w %s", taskID, ErrAgentNotFound, agentID)
+	}
+	if _, ok := m.Tasks[taskID]; !ok {
+		return fmt.Errorf("cannot assign %w %s to agent %s", ErrTaskNotFound, taskID, agentID)
+	}
+	m.assignTaskToAgent(agentID, taskID)
+	return nil
+}
+
+// createAgent, createTask and assignTaskToAgent change the definitions
+// without checking them; m.mu must be held.
+func (m *Manager) createAgent(id, name string, dependsOn []string) *Agent {
+	agent := NewAgent(id, name, dependsOn)
+	m.Agents[id] = agent
+	return agent
+}
+
+func (m *Manager) createTask(id, name string, tool *Tool, inputs map[string]interface{}) *Task {
 	task := NewTask(id, name, tool, inputs)
 	m.Tasks[id] = task
 	return task
 }
 
-func (m *Manager) AssignTaskToAgent(agentID, taskID string) {
-	agent := m.Agents[agentID]
-	task := m.Tasks[taskID]
-	agent.AddTask(task)
+func (m *Manager) assignTaskToAgent(agentID, taskID string) {
+	m.Agents[agentID].AddTask(m.Tasks[taskID])
+}
+
+func (m *Manager) tracer() trace.Tracer {
+	if m.TracerProvider != nil {
+		return m.TracerProvider.Tracer(instrumentationName)
+	}
+	return otel.Tracer(instrumentationName)
+}
+
+type runIDKey struct{}
+
+// ContextWithRunID makes the run started by ExecuteWorkflowContext or
+// ExecuteAllWorkflowsContext with ctx use id as its run ID instead of a
+// random one.
+func ContextWithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+func runIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
 }
 
 func (m *Manager) ExecuteWorkflow() error {
-	executed := make(map[string]bool)
+	return m.ExecuteWorkflowContext(context.Background())
+}
 
-	for len(executed) < len(m.Agents) {
-		for _, agent := range m.Agents {
-			if executed[agent.ID] {
-				continue
-			}
+// ExecuteWorkflowContext executes a new run of all agents with Run.Execute.
+// The run's outputs and results are also copied to the manager's agents and
+// tasks; callers running workflows in parallel should use NewRun instead.
+func (m *Manager) ExecuteWorkflowContext(ctx context.Context) error {
+	run, err := m.NewRun()
+	if err != nil {
+		return err
+	}
+	if id := runIDFromContext(ctx); id != "" {
+		run.ID = id
+	}
+	err = run.Execute(ctx)
+	m.adopt(run)
+	return err
+}
 
-			canExecute := true
-			for _, dep := range agent.DependsOn {
-				if !executed[dep] {
-					canExecute = false
-					break
-				}
-			}
+// adopt copies the outputs and results of run to the manager's definitions.
+func (m *Manager) adopt(run *Run) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, agent := range run.Agents {
+		if definition, ok := m.Agents[id]; ok {
+			definition.Output = agent.Output
+			definition.Iterations = agent.Iterations
+		}
+	}
+	for id, task := range run.Tasks {
+		if definition, ok := m.Tasks[id]; ok {
+			definition.Result = task.Result
+			definition.Stream = task.Stream
+		}
+	}
+}
 
-			if canExecute {
+// Usage returns the usage report of the last workflow run.
+func (m *Manager) Usage() UsageReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
 
-				err := agent.ExecuteTasks()
-				if err != nil {
-					return err
-				}
+// ValidateWorkflow checks that config only refers to registered tools and to
+// tasks and agents it defines, and that agent dependencies have no cycles.
+func (m *Manager) ValidateWorkflow(config WorkflowConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.validateWorkflow(config, nil, nil)
+}
 
-				for _, task := range agent.Tasks {
-					if task.Stream != nil {
-						go func(ch <-chan interface{}) {
+// validateWorkflow checks config on its own, or, when tasks and agents are
+// given, as an addition to those definitions: config may refer to them but
+// not reuse their IDs.
+func (m *Manager) validateWorkflow(config WorkflowConfig, definedTasks map[string]*Task, definedAgents map[string]*Agent) error {
+	tasks := make(map[string]bool)
+	for _, task := range config.Tasks {
+		tool, ok := m.Tools[task.ToolID]
+		if !ok {
+			return fmt.Errorf("task %s uses %w %s", task.ID, ErrToolNotFound, task.ToolID)
+		}
+		if tool.Check != nil {
+			if err := tool.Check(); err != nil {
+				return fmt.Errorf("task %s: %w", task.ID, err)
+			}
+		}
+		if _, ok := definedTasks[task.ID]; ok || tasks[task.ID] {
+			return fmt.Errorf("task %s: %w", task.ID, ErrDuplicateID)
+		}
+		tasks[task.ID] = true
+	}
+	taskDefined := func(id string) bool {
+		_, ok := definedTasks[id]
+		return ok || tasks[id]
+	}
+	for _, task := range config.Tasks {
+		for input, taskID := range task.InputsFrom {
+			if !isTaskReference(taskID, taskDefined) {
+				return fmt.Errorf("task %s takes input '%s' from %w %s", task.ID, input, ErrTaskNotFound, taskID)
+			}
+		}
+		if err := checkForEach(task); err != nil {
+			return err
+		}
+	}
 
-						}(task.Stream)
-					}
-				}
+	dependsOn := make(map[string][]string)
+	agentTasks := make(map[string][]string)
+	for id, agent := range definedAgents {
+		dependsOn[id] = agent.DependsOn
+		for _, task := range agent.Tasks {
+			agentTasks[id] = append(agentTasks[id], task.ID)
+		}
+	}
+	for _, agent := range config.Agents {
+		for _, taskID := range agent.Tasks {
+			if !taskDefined(taskID) {
+				return fmt.Errorf("agent %s runs %w %s", agent.ID, ErrTaskNotFound, taskID)
+			}
+		}
+		if _, ok := dependsOn[agent.ID]; ok {
+			return fmt.Errorf("agent %s: %w", agent.ID, ErrDuplicateID)
+		}
+		if err := checkCondition(agent.ID, agent.Condition, taskDefined); err != nil {
+			return err
+		}
+		if err := checkLoop(agent, taskDefined); err != nil {
+			return err
+		}
+		dependsOn[agent.ID] = agent.DependsOn
+		agentTasks[agent.ID] = agent.Tasks
+	}
+	for _, agent := range config.Agents {
+		for _, dep := range agent.DependsOn {
+			if _, ok := dependsOn[dep]; !ok {
+				return fmt.Errorf("agent %s depends on %w %s", agent.ID, ErrAgentNotFound, dep)
+			}
+		}
+	}
 
-				executed[agent.ID] = true
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("agent dependencies form a cycle through agent %s", id)
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, dep := range dependsOn[id] {
+			if err := visit(dep); err != nil {
+				return err
 			}
 		}
+		state[id] = visited
+		return nil
+	}
+	for _, agent := range config.Agents {
+		if err := visit(agent.ID); err != nil {
+			return err
+		}
 	}
 
+	for _, agent := range config.Agents {
+		if err := checkExpressionScope(agent, dependsOn, agentTasks); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// InitializeWorkflow defines the tasks and agents of config. Tasks and
+// agents may refer to ones defined earlier, but not reuse their IDs. Nothing
+// is defined when config has an error.
 func (m *Manager) InitializeWorkflow(config WorkflowConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validateWorkflow(config, m.Tasks, m.Agents); err != nil {
+		return err
+	}
+	m.defineWorkflow(config)
+	return nil
+}
+
+// defineWorkflow defines the tasks and agents of a checked config; m.mu
+// must be held.
+func (m *Manager) defineWorkflow(config WorkflowConfig) {
 	// Initialize Tasks
 	for _, taskConfig := range config.Tasks {
-		task := m.CreateTask(taskConfig.ID, taskConfig.Name, taskConfig.ToolID, taskConfig.Inputs)
-		if task == nil {
-			return fmt.Errorf("failed to create task: %s", taskConfig.Name)
-		}
+		task := m.createTask(taskConfig.ID, taskConfig.Name, m.Tools[taskConfig.ToolID], taskConfig.Inputs)
+		task.InputsFrom = taskConfig.InputsFrom
+		task.Retries = taskConfig.Retries
+		task.RetryDelay = taskConfig.RetryDelay
+		task.BypassCache = taskConfig.BypassCache
+		task.ForEach = taskConfig.ForEach
+		task.Parallelism = taskConfig.Parallelism
 	}
 
 	// Initialize Agents and Assign Tasks
 	for _, agentConfig := range config.Agents {
-		agent := m.CreateAgent(agentConfig.ID, agentConfig.Name, agentConfig.DependsOn)
+		agent := m.createAgent(agentConfig.ID, agentConfig.Name, agentConfig.DependsOn)
+		agent.Condition = agentConfig.Condition
+		agent.Until = agentConfig.Until
+		agent.MaxIterations = agentConfig.MaxIterations
 		for _, taskID := range agentConfig.Tasks {
-			m.AssignTaskToAgent(agent.ID, taskID)
+			m.assignTaskToAgent(agent.ID, taskID)
 		}
 	}
-
-	return nil
 }
+
 func (m *Manager) ExecuteAllWorkflows() error {
-	executed := make(map[string]bool)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	for len(executed) < len(m.Agents) {
-		for _, agent := range m.Agents {
-			mu.Lock()
-			if executed[agent.ID] {
-				mu.Unlock()
-				continue
-			}
-			canExecute := true
-			for _, dep := range agent.DependsOn {
-				if !executed[dep] {
-					canExecute = false
-					break
-				}
-			}
-			mu.Unlock()
-
-			if canExecute {
-				wg.Add(1)
-				go func(agent *Agent) {
-					defer wg.Done()
-
-					mu.Lock()
-					for _, dep := range agent.DependsOn {
-						for _, task := range agent.Tasks {
-							if output, ok := m.Agents[dep].Output["task_extract_text"]; ok {
-								task.Inputs["pdf_content"] = output
-							}
-							if output, ok := m.Agents[dep].Output["task_query_embedding"]; ok {
-								fmt.Println(output)
-
-								task.Inputs["context"] = output
-							}
-						}
-					}
-					mu.Unlock()
-
-					err := agent.ExecuteTasks()
-					if err != nil {
-						log.Printf("Error executing tasks for agent %s: %v", agent.ID, err)
-					}
-
-					mu.Lock()
-					executed[agent.ID] = true
-					mu.Unlock()
-
-				}(agent)
-			}
-		}
-		wg.Wait()
-	}
+	return m.ExecuteAllWorkflowsContext(context.Background())
+}
 
-	return nil
+// ExecuteAllWorkflowsContext executes a new run of all agents with
+// Run.ExecuteConcurrently and copies its outputs and results like
+// ExecuteWorkflowContext.
+func (m *Manager) ExecuteAllWorkflowsContext(ctx context.Context) error {
+	run, err := m.NewRun()
+	if err != nil {
+		return err
+	}
+	if id := runIDFromContext(ctx); id != "" {
+		run.ID = id
+	}
+	err = run.ExecuteConcurrently(ctx)
+	m.adopt(run)
+	return err
 }
@@ -0,0 +1,191 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestManagerConstructionErrors(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{}, echoTool)
+	if _, err := m.CreateTask("t", "T", "missing", nil); !errors.Is(err, ErrToolNotFound) {
+		t.Errorf("CreateTask with an unknown tool = %v, want ErrToolNotFound", err)
+	}
+	if _, err := m.CreateTask("t", "T", "echo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateTask("t", "T", "echo", nil); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("CreateTask with a used ID = %v, want ErrDuplicateID", err)
+	}
+	if _, err := m.CreateAgent("a", "A", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateAgent("a", "A", nil); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("CreateAgent with a used ID = %v, want ErrDuplicateID", err)
+	}
+	if err := m.AssignTaskToAgent("missing", "t"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("AssignTaskToAgent to an unknown agent = %v, want ErrAgentNotFound", err)
+	}
+	if err := m.AssignTaskToAgent("a", "missing"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("AssignTaskToAgent of an unknown task = %v, want ErrTaskNotFound", err)
+	}
+
+	if err := m.RegisterTool(echoTool); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("RegisterTool twice = %v, want ErrDuplicateID", err)
+	}
+	if err := m.RegisterTool(&Tool{ID: "no-execute"}); err == nil {
+		t.Error("RegisterTool accepted a tool without Execute")
+	}
+	if err := m.UnregisterTool("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UnregisterTool("echo"); !errors.Is(err, ErrToolNotFound) {
+		t.Errorf("UnregisterTool twice = %v, want ErrToolNotFound", err)
+	}
+}
+
+func TestInitializeWorkflowErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WorkflowConfig
+		want   error
+		text   string
+	}{
+		{
+			name:   "unknown tool",
+			config: WorkflowConfig{Tasks: []TaskConfig{{ID: "t", ToolID: "missing"}}},
+			want:   ErrToolNotFound,
+		},
+		{
+			name:   "duplicate task",
+			config: WorkflowConfig{Tasks: []TaskConfig{{ID: "t", ToolID: "echo"}, {ID: "t", ToolID: "echo"}}},
+			want:   ErrDuplicateID,
+		},
+		{
+			name:   "redefined task",
+			config: WorkflowConfig{Tasks: []TaskConfig{{ID: "defined", ToolID: "echo"}}},
+			want:   ErrDuplicateID,
+		},
+		{
+			name:   "unknown input task",
+			config: WorkflowConfig{Tasks: []TaskConfig{{ID: "t", ToolID: "echo", InputsFrom: map[string]string{"value": "missing"}}}},
+			want:   ErrTaskNotFound,
+		},
+		{
+			name:   "unknown agent task",
+			config: WorkflowConfig{Agents: []AgentConfig{{ID: "a", Tasks: []string{"missing"}}}},
+			want:   ErrTaskNotFound,
+		},
+		{
+			name:   "unknown dependency",
+			config: WorkflowConfig{Agents: []AgentConfig{{ID: "a", DependsOn: []string{"missing"}}}},
+			want:   ErrAgentNotFound,
+		},
+		{
+			name: "cycle",
+			config: WorkflowConfig{Agents: []AgentConfig{
+				{ID: "a", DependsOn: []string{"c"}},
+				{ID: "b", DependsOn: []string{"a"}},
+				{ID: "c", DependsOn: []string{"b"}},
+			}},
+			text: "agent dependencies form a cycle",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManager(t, WorkflowConfig{
+				Tasks:  []TaskConfig{{ID: "defined", ToolID: "echo"}},
+				Agents: []AgentConfig{{ID: "first", Tasks: []string{"defined"}}},
+			}, echoTool)
+			// Added tasks are valid so that only the tested error is found.
+			test.config.Tasks = append(test.config.Tasks, TaskConfig{ID: "extra", ToolID: "echo"})
+			test.config.Agents = append(test.config.Agents, AgentConfig{ID: "extra", Tasks: []string{"extra"}})
+
+			err := m.InitializeWorkflow(test.config)
+			if err == nil || test.want != nil && !errors.Is(err, test.want) || !strings.Contains(err.Error(), test.text) {
+				t.Errorf("InitializeWorkflow() = %v, want %v %s", err, test.want, test.text)
+			}
+			if m.Tasks["extra"] != nil || m.Agents["extra"] != nil {
+				t.Error("a workflow with an error was partly defined")
+			}
+		})
+	}
+}
+
+func TestInitializeWorkflowExtendsDefinitions(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "a", ToolID: "echo", Inputs: map[string]interface{}{"value": "from a"}}},
+		Agents: []AgentConfig{{ID: "first", Tasks: []string{"a"}}},
+	}, echoTool)
+	err := m.InitializeWorkflow(WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "b", ToolID: "echo", InputsFrom: map[string]string{"value": "a"}}},
+		Agents: []AgentConfig{{ID: "second", DependsOn: []string{"first"}, Tasks: []string{"b"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExecuteWorkflow(); err != nil {
+		t.Fatal(err)
+	}
+	if result := m.Tasks["b"].Result; result != "from a" {
+		t.Errorf("b = %#v", result)
+	}
+	if err := m.ValidateWorkflow(WorkflowConfig{Tasks: []TaskConfig{{ID: "a", ToolID: "echo"}}}); err != nil {
+		t.Errorf("ValidateWorkflow checks a config against the defined one: %v", err)
+	}
+}
+
+func TestExecuteStopsWhenCancelled(t *testing.T) {
+	var cancel context.CancelFunc
+	cancelling := funcTool("cancel", func(inputs map[string]interface{}) (interface{}, error) {
+		cancel()
+		return "done", nil
+	})
+	for name, execute := range map[string]func(*Run, context.Context) error{
+		"Execute":             (*Run).Execute,
+		"ExecuteConcurrently": (*Run).ExecuteConcurrently,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			cancel = stop
+			m := newTestManager(t, chainConfig("cancel"), cancelling, echoTool)
+			run, _ := m.NewRun()
+			if err := execute(run, ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() = %v, want context.Canceled", name, err)
+			}
+			if run.Tasks["b"].Result != nil {
+				t.Errorf("%s started an agent after the context was cancelled", name)
+			}
+		})
+	}
+}
+
+func TestExecuteReportsDependencyCycles(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{{ID: "t", ToolID: "echo", Inputs: map[string]interface{}{"value": "ran"}}},
+	}, echoTool)
+	for id, deps := range map[string][]string{"ready": nil, "a": {"b"}, "b": {"a"}} {
+		if _, err := m.CreateAgent(id, id, deps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.AssignTaskToAgent("ready", "t"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, execute := range map[string]func(*Run, context.Context) error{
+		"Execute":             (*Run).Execute,
+		"ExecuteConcurrently": (*Run).ExecuteConcurrently,
+	} {
+		run, _ := m.NewRun()
+		err := execute(run, context.Background())
+		if want := "agents a, b cannot start: their dependencies form a cycle"; err == nil || err.Error() != want {
+			t.Errorf("%s() = %v, want %q", name, err, want)
+		}
+		if run.Tasks["t"].Result != "ran" {
+			t.Errorf("%s did not run the agent outside the cycle", name)
+		}
+	}
+}
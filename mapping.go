@@ -0,0 +1,83 @@
+package aicraft
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// checkForEach checks that the input a task maps over is one of its inputs.
+func checkForEach(config TaskConfig) error {
+	if config.ForEach == "" {
+		return nil
+	}
+	if _, ok := config.Inputs[config.ForEach]; ok {
+		return nil
+	}
+	if _, ok := config.InputsFrom[config.ForEach]; ok {
+		return nil
+	}
+	return fmt.Errorf("task %s maps over input '%s', which it does not have", config.ID, config.ForEach)
+}
+
+// executeEach runs the task's tool once for every element of the ForEach
+// input, with that element as the input, at most Parallelism at a time. The
+// results, streamed ones included, are collected in the order of the list.
+// The first failure cancels the elements still running.
+func (t *Task) executeEach(ctx context.Context) (interface{}, error) {
+	list := reflect.ValueOf(t.Inputs[t.ForEach])
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return nil, fmt.Errorf("task %s maps over input '%s', which is not a list", t.ID, t.ForEach)
+	}
+	parallelism := t.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]interface{}, list.Len())
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < list.Len(); i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		inputs := make(map[string]interface{}, len(t.Inputs))
+		for name, value := range t.Inputs {
+			inputs[name] = value
+		}
+		inputs[t.ForEach] = list.Index(i).Interface()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, stream, err := t.Tool.execute(ctx, inputs)
+			if err == nil && stream != nil {
+				hub := NewStreamHub(stream, nil)
+				result = hub.Wait()
+				err = hub.Err()
+			}
+			if err != nil {
+				cancel(fmt.Errorf("element %d of '%s': %w", i, t.ForEach, err))
+				return
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return results, nil
+}
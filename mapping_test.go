@@ -0,0 +1,114 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachCollectsResultsInOrder(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	upper := funcTool("upper", func(inputs map[string]interface{}) (interface{}, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		word := inputs["word"].(string)
+		// Later elements finish first.
+		time.Sleep(time.Duration(10-len(word)) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return strings.ToUpper(word) + inputs["suffix"].(string), nil
+	})
+	list := funcTool("list", func(inputs map[string]interface{}) (interface{}, error) {
+		return []string{"a", "bb", "ccc", "dddd", "eeeee"}, nil
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "words", ToolID: "list"},
+			{ID: "shout", ToolID: "upper", ForEach: "word", Parallelism: 2,
+				InputsFrom: map[string]string{"word": "words"}, Inputs: map[string]interface{}{"suffix": "!"}},
+		},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"words", "shout"}}},
+	}, list, upper)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"A!", "BB!", "CCC!", "DDDD!", "EEEEE!"}
+	if result := run.Tasks["shout"].Result; !reflect.DeepEqual(result, want) {
+		t.Errorf("Result = %#v, want %#v", result, want)
+	}
+	if most != 2 {
+		t.Errorf("%d elements ran at once, want 2", most)
+	}
+}
+
+func TestForEachCollectsStreams(t *testing.T) {
+	spell := &Tool{ID: "spell", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		word := inputs["word"].(string)
+		return nil, sendChunks(word[:1], word[1:]), nil
+	}}
+	task := NewTask("t", "T", spell, map[string]interface{}{"word": []string{"ab", "cd"}})
+	task.ForEach = "word"
+	if err := task.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"ab", "cd"}; !reflect.DeepEqual(task.Result, want) {
+		t.Errorf("Result = %#v, want %#v", task.Result, want)
+	}
+}
+
+func TestForEachStopsAtFirstFailure(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []int
+	tool := &Tool{ID: "t", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		n := inputs["n"].(int)
+		if n == 1 {
+			return nil, nil, errors.New("bad element")
+		}
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			cancelled = append(cancelled, n)
+			mu.Unlock()
+			return nil, nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return n, nil, nil
+		}
+	}}
+	task := NewTask("t", "T", tool, map[string]interface{}{"n": []int{0, 1, 2, 3}})
+	task.ForEach = "n"
+	task.Parallelism = 2
+
+	err := task.Execute(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "element 1 of 'n': bad element") {
+		t.Fatalf("Execute() = %v, want the failed element's error", err)
+	}
+	if !reflect.DeepEqual(cancelled, []int{0}) {
+		t.Errorf("cancelled elements %v, want the one running and none started after", cancelled)
+	}
+}
+
+func TestForEachErrors(t *testing.T) {
+	task := NewTask("t", "T", echoTool, map[string]interface{}{"value": "not a list"})
+	task.ForEach = "value"
+	if err := task.Execute(context.Background()); err == nil || !strings.Contains(err.Error(), "not a list") {
+		t.Errorf("Execute() = %v, want an error for the input", err)
+	}
+
+	m := newTestManager(t, WorkflowConfig{}, echoTool)
+	err := m.InitializeWorkflow(WorkflowConfig{Tasks: []TaskConfig{{ID: "t", ToolID: "echo", ForEach: "missing"}}})
+	if err == nil || !strings.Contains(err.Error(), "maps over input 'missing'") {
+		t.Errorf("InitializeWorkflow() = %v, want the missing input reported", err)
+	}
+}
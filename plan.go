@@ -0,0 +1,401 @@
+package aicraft
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// estimatedCompletionTokens is the length plans assume for chat
+// completions, which cannot be known before they are generated.
+const estimatedCompletionTokens = 500
+
+// Plan describes what a run would do without running it.
+type Plan struct {
+	// Stages run one after another. The agents of a stage only depend on
+	// agents of earlier stages, so ExecuteConcurrently runs them in
+	// parallel.
+	Stages []PlanStage `json:"stages"`
+	// Estimate is the sum of the estimates of the tasks.
+	Estimate TokenUsage `json:"estimate"`
+	// Partial is set when the estimate of a task is.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// PlanStage is a group of agents that can run in parallel.
+type PlanStage struct {
+	Agents []PlanAgent `json:"agents"`
+}
+
+// PlanAgent is an agent of a Plan with its tasks in the order they run.
+type PlanAgent struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name,omitempty"`
+	DependsOn     []string   `json:"depends_on,omitempty"`
+	Condition     string     `json:"condition,omitempty"`
+	Until         string     `json:"until,omitempty"`
+	MaxIterations int        `json:"max_iterations,omitempty"`
+	Tasks         []PlanTask `json:"tasks"`
+}
+
+// PlanTask is a task of a Plan. Inputs holds the inputs the task is
+// defined with, with secrets such as api_key masked; InputsFrom the ones
+// filled from other tasks when it runs. Tasks whose tool calls a model have
+// the Model and an Estimate of their requests, tokens and cost, for every
+// iteration of a loop and every element of a list they map over. The
+// estimate is Partial when it leaves out inputs from other tasks, what the
+// tool cannot know before it runs or, for tools without an Estimate or
+// models without a price, everything.
+type PlanTask struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name,omitempty"`
+	ToolID     string                 `json:"tool"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	InputsFrom map[string]string      `json:"inputs_from,omitempty"`
+	ForEach    string                 `json:"for_each,omitempty"`
+	Model      string                 `json:"model,omitempty"`
+	Estimate   *TokenUsage            `json:"estimate,omitempty"`
+	Partial    bool                   `json:"partial,omitempty"`
+}
+
+// Plan returns the plan of a run of the given agents, or of all agents,
+// like NewRun. Tokens are counted with EstimateTokens on the inputs known
+// before the run; it counts four characters as a token instead of running
+// the model's tokenizer, so estimates are rough. Costs use Prices.
+func (m *Manager) Plan(agentIDs ...string) (*Plan, error) {
+	run, err := m.NewRun(agentIDs...)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := planStages(run.Agents)
+	if err != nil {
+		return nil, err
+	}
+	prices := m.Prices
+	if prices == nil {
+		prices = DefaultPrices
+	}
+
+	plan := &Plan{}
+	for _, ids := range stages {
+		var stage PlanStage
+		for _, id := range ids {
+			agent := run.Agents[id]
+			planned := PlanAgent{
+				ID:            agent.ID,
+				Name:          agent.Name,
+				DependsOn:     agent.DependsOn,
+				Condition:     agent.Condition,
+				Until:         agent.Until,
+				MaxIterations: agent.MaxIterations,
+			}
+			for _, task := range agent.Tasks {
+				plannedTask := planTask(task, agent.MaxIterations, prices)
+				if plannedTask.Estimate != nil {
+					plan.Estimate.Add(*plannedTask.Estimate)
+				}
+				plan.Partial = plan.Partial || plannedTask.Partial
+				planned.Tasks = append(planned.Tasks, plannedTask)
+			}
+			stage.Agents = append(stage.Agents, planned)
+		}
+		plan.Stages = append(plan.Stages, stage)
+	}
+	return plan, nil
+}
+
+// planStages groups the agents by the length of their longest chain of
+// dependencies.
+func planStages(agents map[string]*Agent) ([][]string, error) {
+	depth := make(map[string]int)
+	visiting := make(map[string]bool)
+	var visit func(id string) (int, error)
+	visit = func(id string) (int, error) {
+		if d, ok := depth[id]; ok {
+			return d, nil
+		}
+		if visiting[id] {
+			return 0, fmt.Errorf("agent dependencies form a cycle through agent %s", id)
+		}
+		visiting[id] = true
+		d := 0
+		for _, dep := range agents[id].DependsOn {
+			depDepth, err := visit(dep)
+			if err != nil {
+				return 0, err
+			}
+			if depDepth+1 > d {
+				d = depDepth + 1
+			}
+		}
+		depth[id] = d
+		return d, nil
+	}
+
+	var stages [][]string
+	for id := range agents {
+		d, err := visit(id)
+		if err != nil {
+			return nil, err
+		}
+		for len(stages) <= d {
+			stages = append(stages, nil)
+		}
+		stages[d] = append(stages[d], id)
+	}
+	for _, stage := range stages {
+		sort.Strings(stage)
+	}
+	return stages, nil
+}
+
+func planTask(task *Task, iterations int, prices PriceTable) PlanTask {
+	planned := PlanTask{
+		ID:         task.ID,
+		Name:       task.Name,
+		ToolID:     task.Tool.ID,
+		Inputs:     maskSecrets(task.Inputs),
+		InputsFrom: task.InputsFrom,
+		ForEach:    task.ForEach,
+	}
+	if task.Tool.Estimate == nil {
+		planned.Partial = true
+		return planned
+	}
+
+	inputs := make(map[string]interface{}, len(task.Inputs))
+	for name, value := range task.Inputs {
+		if _, ok := task.InputsFrom[name]; !ok {
+			inputs[name] = value
+		}
+	}
+
+	var usage TokenUsage
+	estimate := func() {
+		estimated := task.Tool.Estimate(inputs, prices)
+		if estimated.Model != "" {
+			planned.Model = estimated.Model
+			if _, ok := prices.Lookup(estimated.Model); !ok {
+				estimated.Partial = true
+			}
+		}
+		planned.Partial = planned.Partial || estimated.Partial
+		usage.Add(estimated.Usage)
+	}
+	list := reflect.ValueOf(inputs[task.ForEach])
+	if task.ForEach != "" && (list.Kind() == reflect.Slice || list.Kind() == reflect.Array) {
+		for i := 0; i < list.Len(); i++ {
+			inputs[task.ForEach] = list.Index(i).Interface()
+			estimate()
+		}
+	} else {
+		if task.ForEach != "" {
+			delete(inputs, task.ForEach)
+			planned.Partial = true
+		}
+		estimate()
+	}
+
+	total := usage
+	for i := 1; i < iterations; i++ {
+		total.Add(usage)
+	}
+	if total.Requests > 0 || total.Cost > 0 {
+		planned.Estimate = &total
+		// Tools that make no requests cost nothing whatever their inputs.
+		planned.Partial = planned.Partial || len(task.InputsFrom) > 0
+	}
+	return planned
+}
+
+// UsageEstimate is the usage a tool expects a task to have. Usage includes
+// its cost.
+type UsageEstimate struct {
+	// Model is the model the task calls, if any.
+	Model string
+	Usage TokenUsage
+	// Partial is set when Usage leaves out what is only known once the task
+	// runs, such as the length of audio.
+	Partial bool
+}
+
+// noUsage is the Estimate of tools that make no billed requests.
+func noUsage(map[string]interface{}, PriceTable) UsageEstimate {
+	return UsageEstimate{}
+}
+
+// tokenEstimate returns the estimate of usage by model with its total
+// tokens and cost.
+func tokenEstimate(model string, usage TokenUsage, prices PriceTable) UsageEstimate {
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens + usage.EmbeddingTokens
+	usage.Cost = prices.Cost(model, usage)
+	return UsageEstimate{Model: model, Usage: usage}
+}
+
+// maskSecrets returns a copy of inputs in which the values of inputs named
+// like API keys, secrets, passwords and tokens are masked.
+func maskSecrets(inputs map[string]interface{}) map[string]interface{} {
+	if inputs == nil {
+		return nil
+	}
+	masked := make(map[string]interface{}, len(inputs))
+	for name, value := range inputs {
+		if isSecret(name) {
+			value = "********"
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+func isSecret(name string) bool {
+	name = strings.ToLower(name)
+	return name == "key" || strings.HasSuffix(name, "_key") || strings.HasSuffix(name, "apikey") ||
+		strings.Contains(name, "secret") || strings.Contains(name, "password") ||
+		name == "token" || strings.HasSuffix(name, "_token")
+}
+
+// estimateChat estimates a request of OpenAIContentGeneratorTool.
+func estimateChat(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "gpt-3.5-turbo"
+	if hasImages(inputs["images"]) {
+		model = "gpt-4o"
+	}
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	query, _ := inputs["query"].(string)
+	prompt := query
+	if contextText, _ := inputs["context"].(string); contextText != "" {
+		chunkSize, _ := inputs["chunkSize"].(int)
+		chunkOverlap, _ := inputs["chunkOverlap"].(int)
+		if chunks := SplitTextIntoChunks(contextText, chunkSize, chunkOverlap); chunkSize > 0 && len(chunks) > 0 {
+			prompt = fmt.Sprintf("Context: %s\n\nQuery: %s", chunks[0], query)
+		}
+	}
+	promptTokens := EstimateTokens(prompt)
+	if promptTokens > maxTokens {
+		promptTokens = maxTokens - 500
+	}
+	return tokenEstimate(model, TokenUsage{Requests: 1, PromptTokens: promptTokens, CompletionTokens: estimatedCompletionTokens}, prices)
+}
+
+// estimateEmbedding estimates a request of QueryToEmbeddingTool.
+func estimateEmbedding(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "text-embedding-ada-002"
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	query, _ := inputs["query"].(string)
+	return tokenEstimate(model, TokenUsage{Requests: 1, EmbeddingTokens: EstimateTokens(query)}, prices)
+}
+
+// estimateDocumentEmbeddings estimates the requests of PDFToEmbeddingsTool.
+func estimateDocumentEmbeddings(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	content, _ := inputs["pdf_content"].(string)
+	chunkSize, _ := inputs["chunkSize"].(int)
+	chunkOverlap, _ := inputs["chunkOverlap"].(int)
+	var usage TokenUsage
+	if chunkSize > 0 {
+		for _, chunk := range SplitTextIntoChunks(content, chunkSize, chunkOverlap) {
+			usage.Requests++
+			usage.EmbeddingTokens += EstimateTokens(chunk)
+		}
+	}
+	return tokenEstimate("text-embedding-ada-002", usage, prices)
+}
+
+// estimateImages estimates a request of the image tools for the 'n' input
+// images with the 'model' input, or dall-e-2, the API's default. Prices are
+// those of standard images; larger and HD images cost more.
+func estimateImages(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "dall-e-2"
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	n, _ := inputs["n"].(int)
+	if n <= 0 {
+		n = 1
+	}
+	price, _ := prices.Lookup(model)
+	return UsageEstimate{Model: model, Usage: TokenUsage{Requests: 1, Cost: float64(n) * price.Image}}
+}
+
+// estimateDiagrams estimates the chat request of DiagramPlannerTool and,
+// when it generates them, max_diagrams images; without max_diagrams the
+// number of images is not known.
+func estimateDiagrams(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "gpt-3.5-turbo"
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	content, _ := inputs["content"].(string)
+	// The instructions add about 150 tokens to the content.
+	estimate := tokenEstimate(model, TokenUsage{Requests: 1, PromptTokens: EstimateTokens(content) + 150, CompletionTokens: estimatedCompletionTokens}, prices)
+	if generate, _ := inputs["generate_images"].(bool); !generate {
+		return estimate
+	}
+	maxDiagrams, _ := inputs["max_diagrams"].(int)
+	if maxDiagrams <= 0 {
+		estimate.Partial = true
+		return estimate
+	}
+	images := estimateImages(map[string]interface{}{"model": inputs["image_model"]}, prices)
+	if _, ok := prices.Lookup(images.Model); !ok {
+		estimate.Partial = true
+	}
+	for i := 0; i < maxDiagrams; i++ {
+		estimate.Usage.Add(images.Usage)
+	}
+	return estimate
+}
+
+// estimateSpeech estimates a request of SpeechTool, which is billed by the
+// characters of its text.
+func estimateSpeech(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "tts-1"
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	text, _ := inputs["text"].(string)
+	price, _ := prices.Lookup(model)
+	return UsageEstimate{Model: model, Usage: TokenUsage{Requests: 1, Cost: float64(len(text)) * price.Characters / 1e6}}
+}
+
+// estimateTranscription estimates a request of TranscriptionTool. It is
+// billed by the minute of audio, which is not known before the request, so
+// the estimate is partial.
+func estimateTranscription(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+	model := "whisper-1"
+	if m, ok := inputs["model"].(string); ok && m != "" {
+		model = m
+	}
+	return UsageEstimate{Model: model, Usage: TokenUsage{Requests: 1}, Partial: true}
+}
+
+// placeholder returns the result the task has in a dry run: the tool's
+// Placeholder, or a text naming the task, for every element of the list it
+// maps over.
+func (t *Task) placeholder() interface{} {
+	result := func(inputs map[string]interface{}) interface{} {
+		if t.Tool.Placeholder != nil {
+			return t.Tool.Placeholder(inputs)
+		}
+		return fmt.Sprintf("(result of %s)", t.ID)
+	}
+	list := reflect.ValueOf(t.Inputs[t.ForEach])
+	if t.ForEach == "" || (list.Kind() != reflect.Slice && list.Kind() != reflect.Array) {
+		return result(t.Inputs)
+	}
+	results := make([]interface{}, list.Len())
+	for i := range results {
+		inputs := make(map[string]interface{}, len(t.Inputs))
+		for name, value := range t.Inputs {
+			inputs[name] = value
+		}
+		inputs[t.ForEach] = list.Index(i).Interface()
+		results[i] = result(inputs)
+	}
+	return results
+}
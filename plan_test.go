@@ -0,0 +1,178 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanStagesAndEstimates(t *testing.T) {
+	query := strings.Repeat("word ", 80) // 400 characters, 100 tokens
+	generate := func(id string) TaskConfig {
+		return TaskConfig{ID: id, ToolID: OpenAIContentGeneratorTool.ID, Inputs: map[string]interface{}{"api_key": "sk-secret", "model": "gpt-4o", "query": query}}
+	}
+	each := generate("each")
+	each.ForEach = "query"
+	each.Inputs["query"] = []interface{}{query, query}
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{generate("first"), generate("left"), generate("loop"), each},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"first"}},
+			{ID: "b", DependsOn: []string{"a"}, Tasks: []string{"left"}},
+			{ID: "c", DependsOn: []string{"a"}, Tasks: []string{"loop"}, MaxIterations: 3},
+			{ID: "d", DependsOn: []string{"b", "c"}, Tasks: []string{"each"}},
+		},
+	})
+	m.Prices = PriceTable{"gpt-4o": {Prompt: 5, Completion: 15}}
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stages [][]string
+	tasks := make(map[string]PlanTask)
+	for _, stage := range plan.Stages {
+		var ids []string
+		for _, agent := range stage.Agents {
+			ids = append(ids, agent.ID)
+			for _, task := range agent.Tasks {
+				tasks[task.ID] = task
+			}
+		}
+		stages = append(stages, ids)
+	}
+	if want := [][]string{{"a"}, {"b", "c"}, {"d"}}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+
+	one := TokenUsage{Requests: 1, PromptTokens: 100, CompletionTokens: 500, TotalTokens: 600, Cost: (100*5 + 500*15) / 1e6}
+	for id, times := range map[string]int{"first": 1, "left": 1, "loop": 3, "each": 2} {
+		task := tasks[id]
+		if task.Estimate == nil || task.Partial || task.Model != "gpt-4o" {
+			t.Errorf("task %s: estimate %+v, partial %v, model %q", id, task.Estimate, task.Partial, task.Model)
+			continue
+		}
+		if task.Estimate.Requests != times || task.Estimate.PromptTokens != 100*times || math.Abs(task.Estimate.Cost-one.Cost*float64(times)) > 1e-12 {
+			t.Errorf("task %s: estimate %+v, want %d times %+v", id, *task.Estimate, times, one)
+		}
+	}
+	if plan.Estimate.Requests != 7 || plan.Partial {
+		t.Errorf("plan estimate = %+v, partial %v, want 7 requests", plan.Estimate, plan.Partial)
+	}
+	if key := tasks["first"].Inputs["api_key"]; key != "********" {
+		t.Errorf("api_key = %v, want it masked", key)
+	}
+	if m.Tasks["first"].Inputs["api_key"] != "sk-secret" {
+		t.Error("masking changed the task's inputs")
+	}
+}
+
+func TestPlanPartialEstimates(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "custom", ToolID: "echo"},
+			{ID: "from", ToolID: OpenAIContentGeneratorTool.ID, InputsFrom: map[string]string{"context": "custom"}, Inputs: map[string]interface{}{"query": "q"}},
+			{ID: "unpriced", ToolID: OpenAIContentGeneratorTool.ID, Inputs: map[string]interface{}{"model": "llama3", "query": "q"}},
+			{ID: "listen", ToolID: TranscriptionTool.ID},
+			{ID: "route", ToolID: RouterTool.ID},
+			{ID: "priced", ToolID: QueryToEmbeddingTool.ID, Inputs: map[string]interface{}{"query": "q"}},
+		},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"custom", "from", "unpriced", "listen", "route", "priced"}}},
+	}, echoTool)
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Partial {
+		t.Error("the plan is not marked partial")
+	}
+	want := map[string]bool{"custom": true, "from": true, "unpriced": true, "listen": true, "route": false, "priced": false}
+	for _, task := range plan.Stages[0].Agents[0].Tasks {
+		if task.Partial != want[task.ID] {
+			t.Errorf("task %s: Partial = %v, want %v", task.ID, task.Partial, want[task.ID])
+		}
+	}
+}
+
+func TestBuiltInToolsHaveEstimates(t *testing.T) {
+	for id, tool := range NewManager().Tools {
+		if tool.Estimate == nil {
+			t.Errorf("tool %s has no Estimate", id)
+		}
+	}
+	if ImageNeedCheckerTool.Estimate == nil {
+		t.Error("ImageNeedCheckerTool has no Estimate")
+	}
+}
+
+func TestToolEstimates(t *testing.T) {
+	prices := PriceTable{"dall-e-3": {Image: 0.04}, "tts-1": {Characters: 15}, "gpt-4o": {Prompt: 5, Completion: 15}}
+	images := estimateImages(map[string]interface{}{"model": "dall-e-3", "n": 2}, prices)
+	if images.Usage.Requests != 1 || math.Abs(images.Usage.Cost-0.08) > 1e-12 {
+		t.Errorf("images = %+v", images)
+	}
+	speech := estimateSpeech(map[string]interface{}{"text": strings.Repeat("a", 1000)}, prices)
+	if math.Abs(speech.Usage.Cost-0.015) > 1e-12 {
+		t.Errorf("speech = %+v", speech)
+	}
+	diagrams := estimateDiagrams(map[string]interface{}{"model": "gpt-4o", "generate_images": true, "max_diagrams": 2, "image_model": "dall-e-3"}, prices)
+	if diagrams.Partial || diagrams.Usage.Requests != 3 || diagrams.Usage.PromptTokens != 150 {
+		t.Errorf("diagrams = %+v", diagrams)
+	}
+	if unbounded := estimateDiagrams(map[string]interface{}{"generate_images": true}, prices); !unbounded.Partial {
+		t.Error("diagrams without max_diagrams are not partial")
+	}
+	// The image does not exist: estimates must not read images.
+	chat := estimateChat(map[string]interface{}{"query": "describe", "images": []string{"missing.png"}}, prices)
+	if chat.Model != "gpt-4o" || chat.Partial {
+		t.Errorf("chat with images = %+v, want the vision model", chat)
+	}
+	embeddings := estimateDocumentEmbeddings(map[string]interface{}{"pdf_content": "one two three four five", "chunkSize": 2}, prices)
+	if embeddings.Usage.Requests != 3 {
+		t.Errorf("document embeddings = %+v, want a request per chunk", embeddings)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	costly := funcTool("costly", func(inputs map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("a tool ran in a dry run")
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "draft", ToolID: "costly"},
+			{ID: "approve", ToolID: ApprovalTool.ID, InputsFrom: map[string]string{"value": "draft"}},
+			{ID: "route", ToolID: RouterTool.ID, InputsFrom: map[string]string{"text": "approve"}, Inputs: map[string]interface{}{
+				"routes":  []interface{}{map[string]interface{}{"if": "text == 'x'", "to": "rare"}},
+				"default": "usual",
+			}},
+			{ID: "usual", ToolID: "costly"},
+			{ID: "rare", ToolID: "costly"},
+		},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"draft", "approve", "route"}},
+			{ID: "usual", DependsOn: []string{"a"}, Condition: `route == "usual"`, Tasks: []string{"usual"}},
+			{ID: "rare", DependsOn: []string{"a"}, Condition: `route == "rare"`, Tasks: []string{"rare"}},
+		},
+	}, costly)
+	store := NewMemoryStore()
+	m.Store = store
+
+	run, _ := m.NewRun()
+	run.DryRun = true
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result := run.Tasks["approve"].Result; result != "(result of draft)" {
+		t.Errorf("approve = %v, want the draft's placeholder approved", result)
+	}
+	if skipped := run.Skipped(); !reflect.DeepEqual(skipped, []string{"rare"}) {
+		t.Errorf("skipped %v, want the router's default branch taken", skipped)
+	}
+	if _, err := store.Load(run.ID); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("a dry run was checkpointed: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package aicraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// NewTypedTool returns a tool that runs fn. The task inputs are decoded into
+// In through their JSON encoding, so In is usually a struct with json tags,
+// and the Out fn returns is the task's result. The tool's Inputs are derived
+// from the fields of In: a field is required unless its tag has omitempty,
+// and a `description` tag documents it. Results of type Out are registered
+// with RegisterResultType so that resumed runs restore them.
+func NewTypedTool[In, Out any](id, name string, fn func(ctx context.Context, in In) (Out, error)) *Tool {
+	var out Out
+	if reflect.TypeOf(out) != nil {
+		RegisterResultType(out)
+	}
+	var in In
+	return &Tool{
+		ID:     id,
+		Name:   name,
+		Inputs: typedInputs(reflect.TypeOf(in)),
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			var in In
+			data, err := json.Marshal(inputs)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to encode inputs: %v", err)
+			}
+			if err := json.Unmarshal(data, &in); err != nil {
+				return nil, nil, fmt.Errorf("invalid inputs: %v", err)
+			}
+			result, err := fn(ctx, in)
+			if err != nil {
+				return nil, nil, err
+			}
+			return result, nil, nil
+		},
+	}
+}
+
+// typedInputs describes the exported fields of struct type t.
+func typedInputs(t reflect.Type) []ToolInput {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var inputs []ToolInput
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		inputs = append(inputs, ToolInput{
+			Name:        name,
+			Type:        inputType(field.Type),
+			Required:    !strings.Contains(options, "omitempty"),
+			Description: field.Tag.Get("description"),
+		})
+	}
+	return inputs
+}
+
+func inputType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Pointer:
+		return inputType(t.Elem())
+	default:
+		return "object"
+	}
+}
+
+// commandRequest is written to the standard input of a command tool.
+type commandRequest struct {
+	Tool   string                 `json:"tool"`
+	Inputs map[string]interface{} `json:"inputs"`
+}
+
+// commandResponse is read from the standard output of a command tool.
+type commandResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// NewCommandTool returns a tool that runs an external program, so tools can
+// be written in any language. For every execution the program is started
+// with args and receives {"tool": id, "inputs": {...}} as JSON on its
+// standard input. It must write {"result": ...} or {"error": "message"} as
+// JSON to its standard output; a non-zero exit status also fails the task.
+// The program is killed when the run is cancelled.
+func NewCommandTool(id, name, command string, args ...string) *Tool {
+	return &Tool{
+		ID:   id,
+		Name: name,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			request, err := json.Marshal(commandRequest{Tool: id, Inputs: inputs})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to encode inputs: %v", err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, command, args...)
+			cmd.Stdin = bytes.NewReader(request)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				if ctx.Err() != nil {
+					return nil, nil, ctx.Err()
+				}
+				if message := strings.TrimSpace(stderr.String()); message != "" {
+					return nil, nil, fmt.Errorf("%s: %v: %s", command, err, message)
+				}
+				return nil, nil, fmt.Errorf("%s: %v", command, err)
+			}
+
+			var response commandResponse
+			if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid response: %v", command, err)
+			}
+			if response.Error != "" {
+				return nil, nil, errors.New(response.Error)
+			}
+			var result interface{}
+			if len(response.Result) > 0 {
+				if err := json.Unmarshal(response.Result, &result); err != nil {
+					return nil, nil, fmt.Errorf("%s: invalid result: %v", command, err)
+				}
+			}
+			return result, nil, nil
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolWithoutContext(t *testing.T) {
+	calls := 0
+	tool := &Tool{ID: "legacy", Name: "Legacy", Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		calls++
+		return inputs["value"], nil, nil
+	}}
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "legacy", Inputs: map[string]interface{}{"value": "kept"}}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}, tool)
+
+	run, err := m.NewRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result := run.Tasks["t"].Result; result != "kept" {
+		t.Errorf("Result = %#v, want kept", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := tool.execute(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("execute with a cancelled context = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("the function ran %d times, want 1", calls)
+	}
+
+	tool.ExecuteContext = func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		return "with context", nil, nil
+	}
+	if result, _, _ := tool.execute(context.Background(), nil); result != "with context" {
+		t.Errorf("execute() = %#v, want ExecuteContext to take precedence", result)
+	}
+}
+
+type greetInput struct {
+	Name   string   `json:"name" description:"who to greet"`
+	Times  int      `json:"times,omitempty"`
+	Titles []string `json:"titles,omitempty"`
+	hidden bool
+}
+
+type greeting struct {
+	Text string `json:"text"`
+}
+
+func TestNewTypedTool(t *testing.T) {
+	tool := NewTypedTool("greet", "Greet", func(ctx context.Context, in greetInput) (greeting, error) {
+		if in.Name == "" {
+			return greeting{}, errors.New("no name")
+		}
+		return greeting{Text: strings.Repeat("Hello, "+in.Name+"! ", in.Times)}, nil
+	})
+
+	want := []ToolInput{
+		{Name: "name", Type: "string", Required: true, Description: "who to greet"},
+		{Name: "times", Type: "int"},
+		{Name: "titles", Type: "list"},
+	}
+	if !reflect.DeepEqual(tool.Inputs, want) {
+		t.Errorf("Inputs = %+v, want %+v", tool.Inputs, want)
+	}
+
+	result, _, err := tool.ExecuteContext(context.Background(), map[string]interface{}{"name": "Ada", "times": 2})
+	if err != nil || result != (greeting{Text: "Hello, Ada! Hello, Ada! "}) {
+		t.Errorf("Execute() = %#v, %v", result, err)
+	}
+	if _, _, err := tool.ExecuteContext(context.Background(), map[string]interface{}{"name": 1}); err == nil || !strings.Contains(err.Error(), "invalid inputs") {
+		t.Errorf("Execute with a number as name = %v, want invalid inputs", err)
+	}
+	if _, _, err := tool.ExecuteContext(context.Background(), nil); err == nil || err.Error() != "no name" {
+		t.Errorf("Execute without a name = %v, want the function's error", err)
+	}
+
+	typeName, data, _ := encodeResult(greeting{Text: "hi"})
+	if restored, _ := decodeResult(typeName, data); restored != (greeting{Text: "hi"}) {
+		t.Errorf("the result type was not registered: restored %#v", restored)
+	}
+}
+
+func TestNewCommandTool(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		result interface{}
+		err    string
+	}{
+		{
+			name:   "echo",
+			script: `printf '{"result": '; cat; printf '}'`,
+			result: map[string]interface{}{"tool": "cmd", "inputs": map[string]interface{}{"value": "x"}},
+		},
+		{name: "no result", script: `echo '{}'`},
+		{name: "error", script: `echo '{"error": "bad input"}'`, err: "bad input"},
+		{name: "exit status", script: `echo broken >&2; exit 3`, err: "exit status 3: broken"},
+		{name: "invalid response", script: `echo nope`, err: "invalid response"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tool := NewCommandTool("cmd", "Command", "sh", "-c", test.script)
+			result, _, err := tool.ExecuteContext(context.Background(), map[string]interface{}{"value": "x"})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Execute() = %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(result, test.result) {
+				t.Errorf("Execute() = %#v, %v, want %#v", result, err, test.result)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	tool := NewCommandTool("slow", "Slow", "sleep", "10")
+	if _, _, err := tool.ExecuteContext(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Execute past the deadline = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,274 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is the rate a model may be used at. Zero fields are not
+// limited.
+type RateLimit struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+// RateLimiter queues provider requests so they stay within per-model rate
+// limits and a maximum number of requests in flight. Limits are tracked
+// separately for every provider base URL and model.
+//
+// When a model has no configured limit the limits reported in the
+// provider's x-ratelimit-* response headers are used, and requests are held
+// back until the reported reset time whenever the provider reports that no
+// requests or tokens remain.
+type RateLimiter struct {
+	// Limits holds the limits of individual models. Default applies to
+	// models without an entry.
+	Limits  map[string]RateLimit
+	Default RateLimit
+
+	mu       sync.Mutex
+	models   map[string]*modelLimiter
+	inFlight chan struct{}
+}
+
+// NewRateLimiter returns a limiter that allows at most maxInFlight
+// concurrent requests, or any number when maxInFlight is 0.
+func NewRateLimiter(maxInFlight int) *RateLimiter {
+	l := &RateLimiter{
+		Limits: make(map[string]RateLimit),
+		models: make(map[string]*modelLimiter),
+	}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// SetLimit sets the limit of model.
+func (l *RateLimiter) SetLimit(model string, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Limits[model] = limit
+}
+
+// modelLimiter holds the request and token buckets of one model of one
+// provider. The buckets refill continuously up to one minute's allowance
+// and may go negative when a request used more tokens than estimated.
+type modelLimiter struct {
+	requests float64
+	tokens   float64
+	updated  time.Time
+	// observed holds the limits reported by the provider.
+	observed RateLimit
+	// blockedUntil is set when the provider reports an exhausted limit.
+	blockedUntil time.Time
+}
+
+func (l *RateLimiter) model(provider, model string) (*modelLimiter, RateLimit) {
+	key := provider + " " + model
+	m, ok := l.models[key]
+	if !ok {
+		m = &modelLimiter{}
+		l.models[key] = m
+	}
+
+	limit, ok := l.Limits[model]
+	if !ok {
+		limit = l.Default
+	}
+	if limit.RequestsPerMinute == 0 {
+		limit.RequestsPerMinute = m.observed.RequestsPerMinute
+	}
+	if limit.TokensPerMinute == 0 {
+		limit.TokensPerMinute = m.observed.TokensPerMinute
+	}
+	return m, limit
+}
+
+// refill tops the buckets up for the time passed since the last call. New
+// buckets start full.
+func (m *modelLimiter) refill(limit RateLimit, now time.Time) {
+	if m.updated.IsZero() {
+		m.requests = float64(limit.RequestsPerMinute)
+		m.tokens = float64(limit.TokensPerMinute)
+		m.updated = now
+		return
+	}
+	elapsed := now.Sub(m.updated).Minutes()
+	m.updated = now
+	m.requests = refillBucket(m.requests, limit.RequestsPerMinute, elapsed)
+	m.tokens = refillBucket(m.tokens, limit.TokensPerMinute, elapsed)
+}
+
+func refillBucket(level float64, perMinute int, elapsed float64) float64 {
+	capacity := float64(perMinute)
+	level += capacity * elapsed
+	if level > capacity {
+		level = capacity
+	}
+	return level
+}
+
+// delay returns how long a request for tokens must wait. Requests for more
+// tokens than a minute's allowance go through once the bucket is full.
+func (m *modelLimiter) delay(limit RateLimit, tokens int, now time.Time) time.Duration {
+	var wait time.Duration
+	if now.Before(m.blockedUntil) {
+		wait = m.blockedUntil.Sub(now)
+	}
+	if limit.RequestsPerMinute > 0 && m.requests < 1 {
+		if d := bucketDelay(1-m.requests, limit.RequestsPerMinute); d > wait {
+			wait = d
+		}
+	}
+	if limit.TokensPerMinute > 0 {
+		need := float64(tokens)
+		if need > float64(limit.TokensPerMinute) {
+			need = float64(limit.TokensPerMinute)
+		}
+		if m.tokens < need {
+			if d := bucketDelay(need-m.tokens, limit.TokensPerMinute); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+func bucketDelay(missing float64, perMinute int) time.Duration {
+	return time.Duration(missing / float64(perMinute) * float64(time.Minute))
+}
+
+// wait blocks until a request of model estimated to use tokens may be sent
+// and returns the function that must be called when it has completed. The
+// in-flight slot is taken first so that queued requests see the limits
+// reported by the responses of those in flight.
+func (l *RateLimiter) wait(ctx context.Context, provider, model string, tokens int) (func(), error) {
+	release := func() {}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() {
+			once.Do(func() { <-l.inFlight })
+		}
+	}
+
+	for {
+		l.mu.Lock()
+		m, limit := l.model(provider, model)
+		now := time.Now()
+		m.refill(limit, now)
+		wait := m.delay(limit, tokens, now)
+		if wait == 0 {
+			if limit.RequestsPerMinute > 0 {
+				m.requests--
+			}
+			if limit.TokensPerMinute > 0 {
+				m.tokens -= float64(tokens)
+			}
+		}
+		l.mu.Unlock()
+
+		if wait == 0 {
+			return release, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// settle charges the difference between the tokens a request actually used
+// and the estimate it was admitted with.
+func (l *RateLimiter) settle(provider, model string, estimated, used int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, limit := l.model(provider, model)
+	if limit.TokensPerMinute > 0 {
+		m.tokens -= float64(used - estimated)
+	}
+}
+
+// observe adapts the limits of model to the x-ratelimit-* headers of a
+// response. The remaining counts the provider reports replace the levels of
+// the buckets.
+func (l *RateLimiter) observe(provider, model string, header http.Header) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, limit := l.model(provider, model)
+	now := time.Now()
+	m.refill(limit, now)
+
+	if limit, err := strconv.Atoi(header.Get("x-ratelimit-limit-requests")); err == nil {
+		m.observed.RequestsPerMinute = limit
+	}
+	if limit, err := strconv.Atoi(header.Get("x-ratelimit-limit-tokens")); err == nil {
+		m.observed.TokensPerMinute = limit
+	}
+	for _, kind := range []string{"requests", "tokens"} {
+		remaining, err := strconv.Atoi(header.Get("x-ratelimit-remaining-" + kind))
+		if err != nil {
+			continue
+		}
+		if kind == "requests" {
+			m.requests = float64(remaining)
+		} else {
+			m.tokens = float64(remaining)
+		}
+		if remaining > 0 {
+			continue
+		}
+		reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-" + kind))
+		if err != nil {
+			continue
+		}
+		if until := now.Add(reset); until.After(m.blockedUntil) {
+			m.blockedUntil = until
+		}
+	}
+}
+
+// requestTokens estimates the tokens a JSON request body uses from the text
+// it contains, with EstimateTokens. Data URLs, which carry attached images,
+// are left out: images are not billed by their encoded size, and settle
+// corrects the estimate once the response reports the usage.
+func requestTokens(body []byte) int {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return EstimateTokens(string(body))
+	}
+	var text strings.Builder
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if !strings.HasPrefix(v, "data:") {
+				text.WriteString(v)
+			}
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		}
+	}
+	collect(payload)
+	return EstimateTokens(text.String())
+}
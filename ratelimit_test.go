@@ -0,0 +1,158 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProvider = "https://provider.test/v1"
+
+// delayOf returns how long a request of model for tokens would wait now.
+func delayOf(l *RateLimiter, model string, tokens int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, limit := l.model(testProvider, model)
+	now := time.Now()
+	m.refill(limit, now)
+	return m.delay(limit, tokens, now)
+}
+
+func TestObserveExhaustedRequests(t *testing.T) {
+	l := NewRateLimiter(0)
+	l.observe(testProvider, "gpt-4o", http.Header{
+		"X-Ratelimit-Limit-Requests":     {"60"},
+		"X-Ratelimit-Remaining-Requests": {"0"},
+		"X-Ratelimit-Reset-Requests":     {"2s"},
+	})
+
+	if got := l.models[testProvider+" gpt-4o"].observed.RequestsPerMinute; got != 60 {
+		t.Errorf("observed requests per minute = %d, want 60", got)
+	}
+	if wait := delayOf(l, "gpt-4o", 0); wait < 1900*time.Millisecond || wait > 2*time.Second {
+		t.Errorf("delay = %v, want about the reported reset of 2s", wait)
+	}
+	if wait := delayOf(l, "gpt-4o-mini", 0); wait != 0 {
+		t.Errorf("another model waits %v", wait)
+	}
+}
+
+func TestObserveRemainingTokens(t *testing.T) {
+	l := NewRateLimiter(0)
+	l.observe(testProvider, "gpt-4o", http.Header{
+		"X-Ratelimit-Limit-Tokens":     {"6000"},
+		"X-Ratelimit-Remaining-Tokens": {"100"},
+		"X-Ratelimit-Reset-Tokens":     {"not a duration"},
+	})
+
+	if wait := delayOf(l, "gpt-4o", 50); wait != 0 {
+		t.Errorf("a request within the remaining tokens waits %v", wait)
+	}
+	// 200 missing tokens refill in 2s at 6000 tokens per minute.
+	if wait := delayOf(l, "gpt-4o", 300); wait < 1900*time.Millisecond || wait > 2*time.Second {
+		t.Errorf("delay = %v, want about 2s", wait)
+	}
+	// Requests larger than a minute's allowance wait for a full bucket.
+	if wait := delayOf(l, "gpt-4o", 100000); wait > time.Minute {
+		t.Errorf("delay = %v, want at most a minute", wait)
+	}
+}
+
+func TestConfiguredLimitsOverrideHeaders(t *testing.T) {
+	l := NewRateLimiter(0)
+	l.SetLimit("gpt-4o", RateLimit{RequestsPerMinute: 600})
+	l.observe(testProvider, "gpt-4o", http.Header{
+		"X-Ratelimit-Limit-Requests": {"1"},
+	})
+	l.mu.Lock()
+	_, limit := l.model(testProvider, "gpt-4o")
+	l.mu.Unlock()
+	if limit.RequestsPerMinute != 600 {
+		t.Errorf("RequestsPerMinute = %d, want the configured 600", limit.RequestsPerMinute)
+	}
+}
+
+func TestWaitQueuesRequests(t *testing.T) {
+	l := NewRateLimiter(0)
+	l.SetLimit("m", RateLimit{RequestsPerMinute: 600})
+	for i := 0; i < 600; i++ {
+		release, err := l.wait(context.Background(), testProvider, "m", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	start := time.Now()
+	release, err := l.wait(context.Background(), testProvider, "m", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	// One request refills every 100ms at 600 requests per minute.
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("the request after the allowance waited %v", waited)
+	}
+}
+
+func TestWaitLimitsRequestsInFlight(t *testing.T) {
+	l := NewRateLimiter(1)
+	release, err := l.wait(context.Background(), testProvider, "m", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(ctx, testProvider, "m", 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second request = %v, want it to wait for the first", err)
+	}
+
+	release()
+	release()
+	second, err := l.wait(context.Background(), testProvider, "m", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second()
+}
+
+func TestClientFollowsRateLimitHeaders(t *testing.T) {
+	var times []time.Time
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			times = append(times, time.Now())
+			w.Header().Set("x-ratelimit-remaining-requests", "0")
+			w.Header().Set("x-ratelimit-reset-requests", "100ms")
+			chatReply("ok")(w, r)
+		},
+	})
+	client, _ := clientFromInputs(context.Background(), providerInputs(srv, nil))
+	client.Limiter = NewRateLimiter(0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.chatCompletion(context.Background(), map[string]interface{}{"model": "gpt-4o"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gap := times[1].Sub(times[0]); gap < 90*time.Millisecond {
+		t.Errorf("the second request was sent %v after the first, want it held back until the reset", gap)
+	}
+}
+
+func TestRequestTokensSkipImageData(t *testing.T) {
+	image := "data:image/png;base64," + strings.Repeat("A", 40000)
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": [
+		{"type": "text", "text": "describe this chart"},
+		{"type": "image_url", "image_url": {"url": "` + image + `"}}
+	]}]}`
+	if tokens := requestTokens([]byte(body)); tokens > 20 {
+		t.Errorf("requestTokens() = %d, want the text only", tokens)
+	}
+	if tokens := requestTokens([]byte(`{"input": "` + strings.Repeat("word ", 100) + `"}`)); tokens != 125 {
+		t.Errorf("requestTokens() = %d, want 125 for 500 characters", tokens)
+	}
+}
@@ -0,0 +1,592 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Run is one execution of a workflow. It has its own copies of the
+// manager's agents and tasks, so the outputs, results and usage of
+// different runs are kept apart and runs of the same workflow can execute in
+// parallel. A Run executes once.
+type Run struct {
+	ID string
+	// Agents and Tasks are the run's copies of the manager's definitions.
+	// They hold the outputs and results once the run has executed.
+	Agents map[string]*Agent
+	Tasks  map[string]*Task
+	// DryRun runs the agents without executing their tools: every task
+	// gets a placeholder result instead, and nothing is checkpointed.
+	DryRun bool
+
+	manager    *Manager
+	resume     *RunState
+	checkpoint *checkpoint
+	// parent is the run whose task parentTask runs this run as a
+	// sub-workflow. Its events are published as the parent's.
+	parent     *Run
+	parentTask string
+
+	mu      sync.Mutex
+	started bool
+	// finished holds the agents that succeeded; conditions see the results
+	// of their tasks.
+	finished map[string]bool
+	usage    UsageReport
+}
+
+// NewRun returns a run of the agents and tasks defined so far. When agent
+// IDs are given, the run only includes those agents and the agents they
+// depend on.
+func (m *Manager) NewRun(agentIDs ...string) (*Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return newRun(m, m.Agents, agentIDs)
+}
+
+// newRun returns a run of the given agent definitions, which are not
+// changed, and the agents they depend on.
+func newRun(m *Manager, agents map[string]*Agent, agentIDs []string) (*Run, error) {
+	include := make(map[string]bool)
+	var add func(id string) error
+	add = func(id string) error {
+		if include[id] {
+			return nil
+		}
+		agent, ok := agents[id]
+		if !ok {
+			return fmt.Errorf("%w %s", ErrAgentNotFound, id)
+		}
+		include[id] = true
+		for _, dep := range agent.DependsOn {
+			if _, ok := agents[dep]; !ok {
+				return fmt.Errorf("agent %s depends on %w %s", id, ErrAgentNotFound, dep)
+			}
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(agentIDs) == 0 {
+		for id := range agents {
+			agentIDs = append(agentIDs, id)
+		}
+	}
+	for _, id := range agentIDs {
+		if err := add(id); err != nil {
+			return nil, err
+		}
+	}
+
+	run := &Run{
+		ID:       newRunID(),
+		Agents:   make(map[string]*Agent),
+		Tasks:    make(map[string]*Task),
+		manager:  m,
+		finished: make(map[string]bool),
+	}
+	for id := range include {
+		definition := agents[id]
+		agent := NewAgent(definition.ID, definition.Name, append([]string(nil), definition.DependsOn...))
+		agent.Condition = definition.Condition
+		agent.Until = definition.Until
+		agent.MaxIterations = definition.MaxIterations
+		for _, task := range definition.Tasks {
+			copied, ok := run.Tasks[task.ID]
+			if !ok {
+				copied = task.copy()
+				run.Tasks[task.ID] = copied
+			}
+			agent.AddTask(copied)
+		}
+		run.Agents[id] = agent
+	}
+	return run, nil
+}
+
+// Results returns the results of the run's tasks that produced one.
+func (r *Run) Results() map[string]interface{} {
+	results := make(map[string]interface{})
+	for id, task := range r.Tasks {
+		if task.Result != nil {
+			results[id] = task.Result
+		}
+	}
+	return results
+}
+
+// Usage returns the usage report of the run once it has executed.
+func (r *Run) Usage() UsageReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+func (r *Run) begin() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return fmt.Errorf("run %s has already been executed", r.ID)
+	}
+	r.started = true
+	return nil
+}
+
+func (r *Run) publish(event Event) {
+	if r.parent != nil {
+		event.Parent = strings.TrimSuffix(r.parentTask+"/"+event.Parent, "/")
+		r.parent.publish(event)
+		return
+	}
+	event.RunID = r.ID
+	r.manager.Events.Publish(event)
+}
+
+// Execute runs the agents one after another in dependency order. ctx is
+// passed to the tools and carries the workflow span.
+func (r *Run) Execute(ctx context.Context) (err error) {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	start := time.Now()
+	ctx, span := r.manager.tracer().Start(ctx, "workflow", trace.WithAttributes(attribute.String("aicraft.run.id", r.ID)))
+	ctx, meter, cancel := r.start(ctx)
+	defer cancel(nil)
+	r.publish(Event{Type: WorkflowStarted})
+	var ran []*Agent
+	defer func() {
+		if err == nil {
+			err = meter.budgetErr()
+		}
+		if cpErr := r.checkpoint.finish(err); err == nil {
+			err = cpErr
+		}
+		if err != nil {
+			spanError(span, err)
+		}
+		span.End()
+		usage := r.finishUsage(meter, ran)
+		r.publish(Event{Type: WorkflowFinished, Duration: time.Since(start), Usage: usage, Err: err})
+	}()
+	if !r.DryRun {
+		if r.checkpoint, err = r.manager.startCheckpoint(r.ID, r.resume); err != nil {
+			return err
+		}
+	}
+	ran, err = r.executeAgents(ctx)
+	return err
+}
+
+// executeAgents runs the agents one after another in dependency order and
+// returns the ones that were not skipped.
+func (r *Run) executeAgents(ctx context.Context) ([]*Agent, error) {
+	var ran []*Agent
+	executed := make(map[string]bool)
+
+	for len(executed) < len(r.Agents) {
+		if ctx.Err() != nil {
+			return ran, context.Cause(ctx)
+		}
+		progressed := false
+		for _, agent := range r.Agents {
+			if executed[agent.ID] {
+				continue
+			}
+
+			canExecute := true
+			for _, dep := range agent.DependsOn {
+				if !executed[dep] {
+					canExecute = false
+					break
+				}
+			}
+
+			if canExecute {
+				skip, err := r.skip(agent)
+				if err != nil {
+					return ran, fmt.Errorf("agent %s: %w", agent.ID, err)
+				}
+				if skip {
+					r.skipAgent(agent)
+				} else {
+					ran = append(ran, agent)
+					if err := r.executeAgent(ctx, agent); err != nil {
+						return ran, err
+					}
+				}
+
+				executed[agent.ID] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			return ran, r.stalled(executed)
+		}
+	}
+
+	return ran, nil
+}
+
+// stalled returns the error of a run in which none of the agents that have
+// not executed can start.
+func (r *Run) stalled(executed map[string]bool) error {
+	var waiting []string
+	for id := range r.Agents {
+		if !executed[id] {
+			waiting = append(waiting, id)
+		}
+	}
+	sort.Strings(waiting)
+	return fmt.Errorf("agents %s cannot start: their dependencies form a cycle", strings.Join(waiting, ", "))
+}
+
+// ExecuteConcurrently runs agents whose dependencies have finished
+// concurrently. Failed agents are logged and do not stop the run, but no
+// agent starts once ctx is done. ctx is passed to the tools and carries the
+// workflow span.
+func (r *Run) ExecuteConcurrently(ctx context.Context) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	start := time.Now()
+	ctx, span := r.manager.tracer().Start(ctx, "workflow", trace.WithAttributes(attribute.String("aicraft.run.id", r.ID)))
+	defer span.End()
+	ctx, meter, cancel := r.start(ctx)
+	defer cancel(nil)
+	r.publish(Event{Type: WorkflowStarted})
+	var err error
+	if !r.DryRun {
+		if r.checkpoint, err = r.manager.startCheckpoint(r.ID, r.resume); err != nil {
+			spanError(span, err)
+			r.publish(Event{Type: WorkflowFinished, Duration: time.Since(start), Err: err})
+			return err
+		}
+	}
+
+	executed := make(map[string]bool)
+	var agentErr, stopErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for len(executed) < len(r.Agents) {
+		if ctx.Err() != nil {
+			stopErr = context.Cause(ctx)
+			break
+		}
+		started := false
+		for _, agent := range r.Agents {
+			mu.Lock()
+			if executed[agent.ID] {
+				mu.Unlock()
+				continue
+			}
+			canExecute := true
+			for _, dep := range agent.DependsOn {
+				if !executed[dep] {
+					canExecute = false
+					break
+				}
+			}
+			mu.Unlock()
+
+			if canExecute {
+				started = true
+				wg.Add(1)
+				go func(agent *Agent) {
+					defer wg.Done()
+
+					skip, err := r.skip(agent)
+					if skip {
+						r.skipAgent(agent)
+					} else if err == nil {
+						err = r.executeAgent(ctx, agent)
+					}
+					if err != nil {
+						log.Printf("Error executing tasks for agent %s: %v", agent.ID, err)
+					}
+
+					mu.Lock()
+					if err != nil && agentErr == nil {
+						agentErr = fmt.Errorf("agent %s: %w", agent.ID, err)
+					}
+					executed[agent.ID] = true
+					mu.Unlock()
+
+				}(agent)
+			}
+		}
+		wg.Wait()
+		if !started {
+			stopErr = r.stalled(executed)
+			break
+		}
+	}
+
+	agents := make([]*Agent, 0, len(r.Agents))
+	for _, agent := range r.Agents {
+		agents = append(agents, agent)
+	}
+	usage := r.finishUsage(meter, agents)
+	err = meter.budgetErr()
+	if err == nil {
+		err = stopErr
+	}
+	// Failed agents are only logged, but the checkpoint records them so the
+	// run can be resumed.
+	failure := err
+	if failure == nil {
+		failure = agentErr
+	}
+	if cpErr := r.checkpoint.finish(failure); err == nil {
+		err = cpErr
+	}
+	if err != nil {
+		spanError(span, err)
+	}
+	r.publish(Event{Type: WorkflowFinished, Duration: time.Since(start), Usage: usage, Err: err})
+	return err
+}
+
+// executeAgent runs the agent's tasks, publishing events for them, and waits
+// for their streams to end.
+func (r *Run) executeAgent(ctx context.Context, agent *Agent) (err error) {
+	start := time.Now()
+	ctx, span := r.manager.tracer().Start(ctx, "agent "+agent.ID, trace.WithAttributes(attribute.String("aicraft.agent.id", agent.ID)))
+	agent.usage.reset()
+	agent.usage.attach(usageMeterFromContext(ctx))
+	ctx = withUsageMeter(ctx, &agent.usage)
+	r.publish(Event{Type: AgentStarted, AgentID: agent.ID})
+	defer func() {
+		if err != nil {
+			spanError(span, err)
+		} else {
+			r.mu.Lock()
+			r.finished[agent.ID] = true
+			r.mu.Unlock()
+		}
+		span.End()
+		r.publish(Event{Type: AgentFinished, AgentID: agent.ID, Duration: time.Since(start), Usage: agent.Usage(), Err: err})
+	}()
+
+	if err := r.prepareAgent(agent); err != nil {
+		return err
+	}
+	// A resumed loop continues with the iteration it was in. Tasks outside
+	// loops are checkpointed with iteration 0.
+	loop := agent.Until != "" || agent.MaxIterations > 1
+	first, taskIteration := 1, 0
+	if loop {
+		if first, err = r.checkpoint.resumeLoop(agent); err != nil {
+			return err
+		}
+	}
+	for iteration := first; ; iteration++ {
+		if loop {
+			taskIteration = iteration
+			if err := r.checkpoint.loopIteration(agent.ID, iteration); err != nil {
+				return err
+			}
+		}
+		err = agent.runTasks(func(task *Task) error {
+			if err := r.prepareTask(task); err != nil {
+				return err
+			}
+			if err := r.executeTask(ctx, agent, task, taskIteration); err != nil {
+				return err
+			}
+			// A task that exceeded the budget cancels the run; stop before
+			// starting the next one.
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := agent.Wait(); err != nil {
+			return err
+		}
+		if done, err := r.loopDone(agent, iteration); done || err != nil {
+			return err
+		}
+	}
+}
+
+// executeTask runs the task in a span, retrying it as configured. Every
+// failed attempt that is retried is recorded as a span event; rejections
+// are not retried, since asking again would not change them. A task that
+// succeeded in the run being resumed, in the same iteration of its agent's
+// loop, is skipped and gets its earlier result.
+func (r *Run) executeTask(ctx context.Context, agent *Agent, task *Task, iteration int) error {
+	ctx, span := r.manager.tracer().Start(ctx, "task "+task.ID, trace.WithAttributes(
+		attribute.String("aicraft.task.id", task.ID),
+		attribute.String("aicraft.tool.id", task.Tool.ID),
+	))
+	defer span.End()
+	ctx = context.WithValue(ctx, runTaskKey{}, runTask{run: r, agentID: agent.ID, taskID: task.ID})
+	task.usage.reset()
+
+	cp := r.checkpoint
+	result, restored, err := cp.restore(task.ID, iteration)
+	if err != nil {
+		return spanError(span, err)
+	}
+	if restored {
+		task.Stream = nil
+		task.Result = result
+		span.SetAttributes(attribute.Bool("aicraft.task.restored", true))
+		r.publish(Event{Type: TaskSkipped, AgentID: agent.ID, TaskID: task.ID})
+		return nil
+	}
+
+	delay := task.RetryDelay
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		span.SetAttributes(attribute.Int("aicraft.task.attempts", attempt))
+		r.publish(Event{Type: TaskStarted, AgentID: agent.ID, TaskID: task.ID, Attempt: attempt})
+		if err := cp.taskStarted(task, iteration, attempt); err != nil {
+			return spanError(span, err)
+		}
+
+		var err error
+		if r.DryRun {
+			task.Stream = nil
+			task.Result = task.placeholder()
+		} else {
+			err = task.Execute(ctx)
+		}
+		if err == nil && task.Stream != nil {
+			for item := range task.Stream.Subscribe() {
+				if chunk := chunkText(item); chunk != "" {
+					r.publish(Event{Type: StreamChunk, AgentID: agent.ID, TaskID: task.ID, Attempt: attempt, Chunk: chunk})
+				}
+			}
+			err = task.Wait()
+		}
+
+		if err == nil {
+			if err := cp.taskFinished(task, iteration, attempt, nil); err != nil {
+				return spanError(span, err)
+			}
+			r.publish(Event{Type: TaskSucceeded, AgentID: agent.ID, TaskID: task.ID, Attempt: attempt, Duration: time.Since(start), Usage: task.Usage()})
+			return nil
+		}
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		if attempt > task.Retries || ctx.Err() != nil || errors.Is(err, ErrRejected) {
+			if cpErr := cp.taskFinished(task, iteration, attempt, err); cpErr != nil {
+				err = errors.Join(err, cpErr)
+			}
+			r.publish(Event{Type: TaskFailed, AgentID: agent.ID, TaskID: task.ID, Attempt: attempt, Duration: time.Since(start), Usage: task.Usage(), Err: err})
+			return spanError(span, err)
+		}
+
+		r.publish(Event{Type: TaskRetried, AgentID: agent.ID, TaskID: task.ID, Attempt: attempt, Duration: time.Since(start), Err: err})
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("aicraft.task.attempt", attempt),
+			attribute.String("exception.message", err.Error()),
+		))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// prepareAgent waits for the streams of the agent's dependencies to end.
+func (r *Run) prepareAgent(agent *Agent) error {
+	for _, dep := range agent.DependsOn {
+		if err := r.Agents[dep].Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareTask fills the inputs the task takes from other tasks' results
+// right before it runs, so it also sees the results of earlier tasks of its
+// agent and, in a loop, of the previous iteration. Inputs whose task has no
+// result yet keep their value.
+func (r *Run) prepareTask(task *Task) error {
+	for input, taskID := range task.InputsFrom {
+		source, output := r.Tasks[taskID], ""
+		if source == nil {
+			taskID, output, _ = strings.Cut(taskID, ".")
+			source = r.Tasks[taskID]
+		}
+		if source == nil {
+			continue
+		}
+		if err := source.Wait(); err != nil {
+			return err
+		}
+		if source.Result == nil {
+			continue
+		}
+		value := source.Result
+		if output != "" {
+			var err error
+			if value, err = outputOf(source, output); err != nil {
+				return err
+			}
+		}
+		if value == nil {
+			continue
+		}
+		if task.Inputs == nil {
+			task.Inputs = make(map[string]interface{})
+		}
+		task.Inputs[input] = value
+	}
+	return nil
+}
+
+// start returns a context carrying the client and the meter of the run. The
+// context is cancelled with the budget error when the run exceeds the
+// manager's Budget.
+func (r *Run) start(ctx context.Context) (context.Context, *usageMeter, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	meter := newRootMeter(r.manager.Prices, r.manager.Budget, cancel)
+	if r.manager.Client != nil {
+		ctx = withClient(ctx, r.manager.Client)
+	}
+	return withUsageMeter(ctx, meter), meter, cancel
+}
+
+// finishUsage stores the usage report of the agents that executed, also as
+// the manager's last report, and returns its total.
+func (r *Run) finishUsage(meter *usageMeter, agents []*Agent) TokenUsage {
+	report := UsageReport{
+		Total:  meter.total(),
+		Agents: make(map[string]TokenUsage),
+		Tasks:  make(map[string]TokenUsage),
+		Models: meter.modelUsage(),
+	}
+	for _, agent := range agents {
+		report.Agents[agent.ID] = agent.Usage()
+		for _, task := range agent.Tasks {
+			report.Tasks[task.ID] = task.Usage()
+		}
+	}
+
+	r.mu.Lock()
+	r.usage = report
+	r.mu.Unlock()
+	r.manager.mu.Lock()
+	r.manager.usage = report
+	r.manager.mu.Unlock()
+	return report.Total
+}
@@ -0,0 +1,157 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// chainConfig has agent "first" running task "a" and agent "second", which
+// depends on it, running task "b" with a's result as its 'value' input.
+func chainConfig(tool string) WorkflowConfig {
+	return WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "a", ToolID: tool},
+			{ID: "b", ToolID: "echo", InputsFrom: map[string]string{"value": "a"}},
+		},
+		Agents: []AgentConfig{
+			{ID: "first", Tasks: []string{"a"}},
+			{ID: "second", DependsOn: []string{"first"}, Tasks: []string{"b"}},
+		},
+	}
+}
+
+func TestParallelRunsKeepTheirResults(t *testing.T) {
+	var calls int64
+	count := funcTool("count", func(inputs map[string]interface{}) (interface{}, error) {
+		return atomic.AddInt64(&calls, 1), nil
+	})
+	m := newTestManager(t, chainConfig("count"), count, echoTool)
+
+	runs := make([]*Run, 8)
+	var wg sync.WaitGroup
+	for i := range runs {
+		run, err := m.NewRun()
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs[i] = run
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run.Execute(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[interface{}]bool)
+	for _, run := range runs {
+		results := run.Results()
+		if results["a"] != results["b"] || seen[results["a"]] {
+			t.Errorf("run %s has results %v, want its own count passed from a to b", run.ID, results)
+		}
+		seen[results["a"]] = true
+	}
+	if m.Tasks["a"].Result != nil || len(m.Agents["first"].Output) != 0 {
+		t.Error("runs changed the manager's definitions")
+	}
+	if err := runs[0].Execute(context.Background()); err == nil {
+		t.Error("a run executed twice")
+	}
+}
+
+func TestExecuteWorkflowIsRerunnable(t *testing.T) {
+	var calls int64
+	count := funcTool("count", func(inputs map[string]interface{}) (interface{}, error) {
+		return atomic.AddInt64(&calls, 1), nil
+	})
+	m := newTestManager(t, chainConfig("count"), count, echoTool)
+
+	for want := int64(1); want <= 2; want++ {
+		if err := m.ExecuteWorkflow(); err != nil {
+			t.Fatal(err)
+		}
+		if result := m.Tasks["b"].Result; result != want {
+			t.Errorf("execution %d: b = %v", want, result)
+		}
+	}
+
+	ctx := ContextWithRunID(context.Background(), "chosen-id")
+	recorder := recordEvents(m)
+	if err := m.ExecuteWorkflowContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if event := recorder.events[0]; event.RunID != "chosen-id" {
+		t.Errorf("run ID = %q, want the one from the context", event.RunID)
+	}
+}
+
+func TestNewRunOfSomeAgents(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{{ID: "a", ToolID: "echo"}, {ID: "b", ToolID: "echo"}, {ID: "c", ToolID: "echo"}},
+		Agents: []AgentConfig{
+			{ID: "first", Tasks: []string{"a"}},
+			{ID: "second", DependsOn: []string{"first"}, Tasks: []string{"b"}},
+			{ID: "other", Tasks: []string{"c"}},
+		},
+	}, echoTool)
+
+	run, err := m.NewRun("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Agents) != 2 || run.Agents["first"] == nil || run.Tasks["c"] != nil {
+		t.Errorf("run has agents %v and tasks %v, want second and its dependency", run.Agents, run.Tasks)
+	}
+	if _, err := m.NewRun("missing"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("NewRun(missing) = %v, want ErrAgentNotFound", err)
+	}
+}
+
+func TestExecuteConcurrentlyRunsReadyAgentsTogether(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	together := funcTool("together", func(inputs map[string]interface{}) (interface{}, error) {
+		started.Done()
+		done := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return "ok", nil
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("the other agent did not start")
+		}
+	})
+	broken := funcTool("broken", func(inputs map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("no luck")
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{{ID: "x", ToolID: "together"}, {ID: "y", ToolID: "together"}, {ID: "z", ToolID: "broken"}},
+		Agents: []AgentConfig{
+			{ID: "left", Tasks: []string{"x"}},
+			{ID: "right", Tasks: []string{"y"}},
+			{ID: "failing", Tasks: []string{"z"}},
+		},
+	}, together, broken)
+	m.Store = NewMemoryStore()
+
+	run, _ := m.NewRun()
+	if err := run.ExecuteConcurrently(context.Background()); err != nil {
+		t.Fatalf("ExecuteConcurrently() = %v, want failed agents not to stop the run", err)
+	}
+	if run.Tasks["x"].Result != "ok" || run.Tasks["y"].Result != "ok" {
+		t.Errorf("results = %v", run.Results())
+	}
+	state, _ := m.Store.Load(run.ID)
+	if state.Status != StatusFailed {
+		t.Errorf("checkpoint status = %s, want the failed agent recorded", state.Status)
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DevMaan707/aicraft"
+	"gopkg.in/yaml.v3"
+)
+
+// maxBodySize limits the size of posted workflows and inputs.
+const maxBodySize = 1 << 20
+
+// Event is the JSON representation of an aicraft.Event.
+type Event struct {
+	Type     aicraft.EventType   `json:"type"`
+	RunID    string              `json:"run_id"`
+	Parent   string              `json:"parent,omitempty"`
+	AgentID  string              `json:"agent_id,omitempty"`
+	TaskID   string              `json:"task_id,omitempty"`
+	Time     time.Time           `json:"time"`
+	Duration float64             `json:"duration_ms,omitempty"`
+	Attempt  int                 `json:"attempt,omitempty"`
+	Chunk    string              `json:"chunk,omitempty"`
+	Usage    *aicraft.TokenUsage `json:"usage,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+func newEvent(event aicraft.Event) Event {
+	e := Event{
+		Type:     event.Type,
+		RunID:    event.RunID,
+		Parent:   event.Parent,
+		AgentID:  event.AgentID,
+		TaskID:   event.TaskID,
+		Time:     event.Time,
+		Duration: float64(event.Duration) / float64(time.Millisecond),
+		Attempt:  event.Attempt,
+		Chunk:    event.Chunk,
+	}
+	if event.Usage != (aicraft.TokenUsage{}) {
+		usage := event.Usage
+		e.Usage = &usage
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	return e
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "workflows":
+		s.allow(w, r, http.MethodGet, func() {
+			writeJSON(w, http.StatusOK, map[string][]string{"workflows": s.Workflows()})
+		})
+	case len(parts) == 3 && parts[0] == "workflows" && parts[2] == "runs":
+		s.allow(w, r, http.MethodPost, func() { s.startRegistered(w, r, parts[1]) })
+	case len(parts) == 1 && parts[0] == "runs":
+		switch r.Method {
+		case http.MethodGet:
+			s.listRuns(w)
+		case http.MethodPost:
+			s.startPosted(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(parts) == 2 && parts[0] == "runs":
+		run := s.Run(parts[1])
+		if run == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", parts[1]))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, run.Info(true))
+		case http.MethodDelete:
+			run.Cancel()
+			writeJSON(w, http.StatusAccepted, run.Info(false))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	case len(parts) == 3 && parts[0] == "runs" && parts[2] == "events":
+		run := s.Run(parts[1])
+		if run == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", parts[1]))
+			return
+		}
+		s.allow(w, r, http.MethodGet, func() { s.streamEvents(w, r, run) })
+	case len(parts) >= 4 && parts[0] == "runs" && parts[2] == "approvals":
+		run := s.Run(parts[1])
+		if run == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", parts[1]))
+			return
+		}
+		// Tasks of sub-workflows are addressed by their path.
+		s.allow(w, r, http.MethodPost, func() { s.decide(w, r, run, strings.Join(parts[3:], "/")) })
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, method string, handle func()) {
+	if r.Method != method {
+		methodNotAllowed(w, method)
+		return
+	}
+	handle()
+}
+
+func (s *Server) startRegistered(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	config, ok := s.workflows[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("workflow %s not found", name))
+		return
+	}
+
+	// Inputs are decoded as YAML, a superset of JSON, so whole numbers
+	// become ints as the tools expect.
+	var body struct {
+		Inputs map[string]map[string]interface{} `yaml:"inputs"`
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := yaml.Unmarshal(data, &body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	s.start(w, name, config, body.Inputs)
+}
+
+func (s *Server) startPosted(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	config, err := aicraft.ParseWorkflowConfig(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid workflow: %v", err))
+		return
+	}
+	s.start(w, "", *config, nil)
+}
+
+func (s *Server) start(w http.ResponseWriter, name string, config aicraft.WorkflowConfig, inputs map[string]map[string]interface{}) {
+	run, err := s.Start(name, config, inputs)
+	switch {
+	case errors.Is(err, ErrServerClosed):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeJSON(w, http.StatusAccepted, run.Info(false))
+	}
+}
+
+func (s *Server) listRuns(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.evict()
+	runs := make([]*Run, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, run)
+	}
+	s.mu.Unlock()
+
+	infos := make([]RunInfo, len(runs))
+	for i, run := range runs {
+		infos[i] = run.Info(false)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.Before(infos[j].StartedAt) })
+	writeJSON(w, http.StatusOK, map[string][]RunInfo{"runs": infos})
+}
+
+func (s *Server) decide(w http.ResponseWriter, r *http.Request, run *Run, taskID string) {
+	// Decoded as YAML like inputs, so an edited value keeps whole numbers
+	// as ints.
+	var decision aicraft.Decision
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := yaml.Unmarshal(data, &decision); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid decision: %v", err))
+		return
+	}
+	err = run.Decide(taskID, decision)
+	switch {
+	case errors.Is(err, aicraft.ErrApprovalNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, aicraft.ErrRunExecuting):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, run.Info(false))
+	}
+}
+
+// streamEvents sends the events of run, starting with those that already
+// happened, until the run finishes, the client goes away or the server
+// shuts down. StreamChunk events are sent as "token" events and the stream
+// ends with a "done" event carrying the run's status.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, run *Run) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sent := 0
+	for {
+		events, finished, changed := run.eventsSince(sent)
+		for _, event := range events {
+			name := string(event.Type)
+			if event.Type == aicraft.StreamChunk {
+				name = "token"
+			}
+			if err := writeSSE(w, name, event); err != nil {
+				return
+			}
+		}
+		sent += len(events)
+		flusher.Flush()
+
+		if finished {
+			writeSSE(w, "done", run.Info(false))
+			flusher.Flush()
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func writeSSE(w io.Writer, name string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(map[string]string{"error": fmt.Sprintf("failed to encode response: %v", err)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
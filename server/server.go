@@ -0,0 +1,468 @@
+// Package server exposes workflows over HTTP. Clients start runs of
+// registered workflows or of posted definitions, poll their status and
+// results, and follow their events and streamed tokens with Server-Sent
+// Events.
+//
+//	GET    /workflows               names of the registered workflows
+//	POST   /workflows/{name}/runs   run a registered workflow
+//	POST   /runs                    run the YAML or JSON workflow in the body
+//	GET    /runs                    all runs that have not been evicted
+//	GET    /runs/{id}               status, results and usage of a run
+//	GET    /runs/{id}/events        events of a run as Server-Sent Events
+//	POST   /runs/{id}/approvals/{task...}
+//	                                approve or reject the task waiting in a run
+//	DELETE /runs/{id}               cancel a run
+//
+// Runs share the provider client of the server, so they are rate limited
+// and cached together. Posted workflows can read local files through tool
+// inputs such as pdf_path, so the server should only be exposed to trusted
+// clients.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+// ErrServerClosed is returned when a run is started after Shutdown.
+var ErrServerClosed = errors.New("server is shutting down")
+
+// Server runs workflows for HTTP clients and keeps their runs in memory
+// until they are evicted some time after they finish.
+type Server struct {
+	// NewManager returns the manager a run executes in. New sets it to
+	// create managers that share one client.
+	NewManager func() *aicraft.Manager
+	// AllowBaseURL permits the 'base_url' task input, also when a
+	// sub-workflow or for_each sets it, and taking 'base_url' or 'api_key'
+	// from the result of another task. They are rejected by default because
+	// a client could have the server's API key sent to any URL it chooses.
+	AllowBaseURL bool
+	// MaxFinishedRuns is how many finished runs are kept; the runs that
+	// finished first are forgotten beyond it. Zero keeps all of them. New
+	// sets it to 1000.
+	MaxFinishedRuns int
+	// FinishedRunTTL is how long a finished run is kept. Zero keeps it
+	// until MaxFinishedRuns evicts it. New sets it to one hour.
+	FinishedRunTTL time.Duration
+
+	mu        sync.Mutex
+	workflows map[string]aicraft.WorkflowConfig
+	// subworkflows are the names of the registered workflows with inputs or
+	// outputs, in the order they were registered.
+	subworkflows []string
+	runs         map[string]*Run
+	closing      bool
+	done         chan struct{}
+	wg           sync.WaitGroup
+	http         *http.Server
+}
+
+// New returns a server without registered workflows.
+func New() *Server {
+	client := aicraft.NewManager().Client
+	return &Server{
+		NewManager: func() *aicraft.Manager {
+			manager := aicraft.NewManager()
+			manager.Client = client
+			return manager
+		},
+		MaxFinishedRuns: 1000,
+		FinishedRunTTL:  time.Hour,
+		workflows:       make(map[string]aicraft.WorkflowConfig),
+		runs:            make(map[string]*Run),
+		done:            make(chan struct{}),
+	}
+}
+
+// RegisterWorkflow makes config available to run by name. When config has
+// inputs or outputs, every run can also use it as a sub-workflow: it is
+// registered with Manager.RegisterWorkflow as the tool name, after the
+// sub-workflows registered before it.
+func (s *Server) RegisterWorkflow(name string, config aicraft.WorkflowConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, subworkflow := range s.subworkflows {
+		if subworkflow == name {
+			s.subworkflows = append(s.subworkflows[:i:i], s.subworkflows[i+1:]...)
+			break
+		}
+	}
+	if len(config.Inputs) > 0 || len(config.Outputs) > 0 {
+		s.subworkflows = append(s.subworkflows, name)
+	}
+	s.workflows[name] = config
+}
+
+// registerSubworkflows registers the sub-workflows in manager.
+func (s *Server) registerSubworkflows(manager *aicraft.Manager) error {
+	s.mu.Lock()
+	configs := make([]aicraft.WorkflowConfig, len(s.subworkflows))
+	for i, name := range s.subworkflows {
+		configs[i] = s.workflows[name]
+	}
+	names := append([]string(nil), s.subworkflows...)
+	s.mu.Unlock()
+
+	for i, name := range names {
+		if err := manager.RegisterWorkflow(name, name, configs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Workflows returns the names of the registered workflows in order.
+func (s *Server) Workflows() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.workflows))
+	for name := range s.workflows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run returns the run with id, or nil.
+func (s *Server) Run(id string) *Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	return s.runs[id]
+}
+
+// evict forgets the finished runs that are older than FinishedRunTTL or
+// beyond MaxFinishedRuns. s.mu must be held.
+func (s *Server) evict() {
+	now := time.Now()
+	var finished []*Run
+	for id, run := range s.runs {
+		run.mu.Lock()
+		running, at := run.status == StatusRunning, run.finished
+		run.mu.Unlock()
+		switch {
+		case running:
+		case s.FinishedRunTTL > 0 && now.Sub(at) > s.FinishedRunTTL:
+			delete(s.runs, id)
+		default:
+			finished = append(finished, run)
+		}
+	}
+	if s.MaxFinishedRuns <= 0 || len(finished) <= s.MaxFinishedRuns {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].finishedAt().Before(finished[j].finishedAt()) })
+	for _, run := range finished[:len(finished)-s.MaxFinishedRuns] {
+		delete(s.runs, run.ID)
+	}
+}
+
+// Start validates config and runs it in the background. overrides set task
+// inputs by task ID and input name.
+func (s *Server) Start(workflow string, config aicraft.WorkflowConfig, overrides map[string]map[string]interface{}) (*Run, error) {
+	config = copyConfig(config)
+	tasks := make(map[string]*aicraft.TaskConfig)
+	for i := range config.Tasks {
+		tasks[config.Tasks[i].ID] = &config.Tasks[i]
+	}
+	for taskID, inputs := range overrides {
+		task, ok := tasks[taskID]
+		if !ok {
+			return nil, fmt.Errorf("inputs given for unknown task %s", taskID)
+		}
+		for name, value := range inputs {
+			task.Inputs[name] = value
+		}
+	}
+	if !s.AllowBaseURL {
+		for _, task := range config.Tasks {
+			if _, ok := task.Inputs["base_url"]; ok {
+				return nil, fmt.Errorf("task %s: input 'base_url' is not allowed", task.ID)
+			}
+			// Results of other tasks, such as a router's default, are
+			// chosen by the client too.
+			for _, input := range []string{"base_url", "api_key"} {
+				if _, ok := task.InputsFrom[input]; ok {
+					return nil, fmt.Errorf("task %s: input '%s' cannot be taken from another task", task.ID, input)
+				}
+			}
+		}
+	}
+
+	manager := s.NewManager()
+	if err := s.registerSubworkflows(manager); err != nil {
+		return nil, err
+	}
+	if err := manager.ValidateWorkflow(config); err != nil {
+		return nil, err
+	}
+	if err := manager.InitializeWorkflow(config); err != nil {
+		return nil, err
+	}
+	execution, err := manager.NewRun()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return nil, ErrServerClosed
+	}
+	run := newRun(workflow, manager, execution, s.AllowBaseURL)
+	s.runs[run.ID] = run
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		run.execute()
+		s.mu.Lock()
+		s.evict()
+		s.mu.Unlock()
+	}()
+	return run, nil
+}
+
+// ListenAndServe serves the API on addr until Shutdown is called.
+func (s *Server) ListenAndServe(addr string) error {
+	s.mu.Lock()
+	s.http = &http.Server{Addr: addr, Handler: s.Handler()}
+	srv := s.http
+	s.mu.Unlock()
+
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting runs, ends event streams, stops the HTTP server
+// started by ListenAndServe and waits for running workflows to finish. When
+// ctx ends first the remaining runs are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closing {
+		s.closing = true
+		close(s.done)
+	}
+	srv := s.http
+	s.mu.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		s.mu.Lock()
+		for _, run := range s.runs {
+			run.Cancel()
+		}
+		s.mu.Unlock()
+		<-finished
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// copyConfig copies config deeply enough that changing task inputs does not
+// affect the original.
+func copyConfig(config aicraft.WorkflowConfig) aicraft.WorkflowConfig {
+	tasks := make([]aicraft.TaskConfig, len(config.Tasks))
+	for i, task := range config.Tasks {
+		inputs := make(map[string]interface{}, len(task.Inputs))
+		for name, value := range task.Inputs {
+			inputs[name] = value
+		}
+		task.Inputs = inputs
+		tasks[i] = task
+	}
+	config.Tasks = tasks
+	config.Agents = append([]aicraft.AgentConfig(nil), config.Agents...)
+	return config
+}
+
+// Status is the state of a run.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Run is a workflow execution started by the server.
+type Run struct {
+	ID       string
+	Workflow string
+
+	manager   *aicraft.Manager
+	execution *aicraft.Run
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	status   Status
+	err      error
+	started  time.Time
+	finished time.Time
+	events   []Event
+	changed  chan struct{}
+}
+
+func newRun(workflow string, manager *aicraft.Manager, execution *aicraft.Run, allowBaseURL bool) *Run {
+	ctx := context.Background()
+	if !allowBaseURL {
+		// Inputs computed while the run executes, such as for_each
+		// elements, are checked when the tools use them.
+		ctx = aicraft.ContextWithoutBaseURL(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	run := &Run{
+		ID:        execution.ID,
+		Workflow:  workflow,
+		manager:   manager,
+		execution: execution,
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    StatusRunning,
+		started:   time.Now(),
+		changed:   make(chan struct{}),
+	}
+	manager.Events.OnEvent(run.record)
+	return run
+}
+
+func (r *Run) finishedAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finished
+}
+
+func (r *Run) execute() {
+	defer r.cancel()
+	err := r.execution.Execute(r.ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err == nil && r.err == nil:
+		r.status = StatusSucceeded
+	case err != nil && r.ctx.Err() != nil:
+		r.status = StatusCancelled
+		r.err = err
+	default:
+		r.status = StatusFailed
+		if err != nil {
+			r.err = err
+		}
+	}
+	r.finished = time.Now()
+	r.notify()
+}
+
+// Approvals returns the approvals the run is waiting for.
+func (r *Run) Approvals() []aicraft.Approval {
+	var approvals []aicraft.Approval
+	for _, approval := range r.manager.Approvals() {
+		if approval.RunID == r.ID {
+			approvals = append(approvals, approval)
+		}
+	}
+	return approvals
+}
+
+// Decide answers the approval task taskID of the run is waiting for.
+func (r *Run) Decide(taskID string, decision aicraft.Decision) error {
+	return r.manager.Decide(r.ID, taskID, decision)
+}
+
+// Cancel stops the run.
+func (r *Run) Cancel() {
+	r.cancel()
+}
+
+func (r *Run) record(event aicraft.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event.Type == aicraft.TaskFailed && event.Parent == "" && r.err == nil {
+		r.err = fmt.Errorf("task %s: %w", event.TaskID, event.Err)
+	}
+	r.events = append(r.events, newEvent(event))
+	r.notify()
+}
+
+// notify wakes the event streams waiting for the run. r.mu must be held.
+func (r *Run) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// eventsSince returns the events after the first n, whether the run has
+// finished and a channel that is closed when that changes.
+func (r *Run) eventsSince(n int) ([]Event, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []Event
+	if n < len(r.events) {
+		events = append(events, r.events[n:]...)
+	}
+	return events, r.status != StatusRunning, r.changed
+}
+
+// RunInfo is the JSON representation of a run.
+type RunInfo struct {
+	ID         string                 `json:"id"`
+	Workflow   string                 `json:"workflow,omitempty"`
+	Status     Status                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	Results    map[string]interface{} `json:"results,omitempty"`
+	Skipped    []string               `json:"skipped,omitempty"`
+	Approvals  []aicraft.Approval     `json:"approvals,omitempty"`
+	Usage      *aicraft.UsageReport   `json:"usage,omitempty"`
+}
+
+// Info describes the run. Results and usage are included once it has
+// finished.
+func (r *Run) Info(withResults bool) RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := RunInfo{ID: r.ID, Workflow: r.Workflow, Status: r.status, StartedAt: r.started}
+	if r.err != nil {
+		info.Error = r.err.Error()
+	}
+	if r.status == StatusRunning {
+		info.Approvals = r.Approvals()
+		return info
+	}
+	finished := r.finished
+	info.FinishedAt = &finished
+	if withResults {
+		info.Results = r.execution.Results()
+		info.Skipped = r.execution.Skipped()
+		usage := r.execution.Usage()
+		info.Usage = &usage
+	}
+	return info
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DevMaan707/aicraft"
+)
+
+// testServer returns a server whose runs can use the tools "echo", which
+// returns its 'value' input, "stream", which streams its 'words', and
+// "block", which waits until the run is cancelled.
+func testServer(t *testing.T) (*Server, *httptest.Server) {
+	s := New()
+	s.NewManager = func() *aicraft.Manager {
+		m := aicraft.NewManager()
+		for _, tool := range []*aicraft.Tool{
+			{ID: "echo", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+				return inputs["value"], nil, nil
+			}},
+			{ID: "stream", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+				words, _ := inputs["words"].([]interface{})
+				stream := make(chan interface{}, len(words))
+				for _, word := range words {
+					stream <- word
+				}
+				close(stream)
+				return nil, stream, nil
+			}},
+			{ID: "block", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+				<-ctx.Done()
+				return nil, nil, ctx.Err()
+			}},
+		} {
+			if err := m.RegisterTool(tool); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return m
+	}
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		s.Shutdown(context.Background())
+	})
+	return s, srv
+}
+
+func singleTask(tool string, inputs map[string]interface{}) aicraft.WorkflowConfig {
+	return aicraft.WorkflowConfig{
+		Tasks:  []aicraft.TaskConfig{{ID: "t", ToolID: tool, Inputs: inputs}},
+		Agents: []aicraft.AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}
+}
+
+func request(t *testing.T, method, url, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// sseEvent is a Server-Sent Event read by readEvents.
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents reads the event stream of run id until it ends.
+func readEvents(t *testing.T, srv *httptest.Server, id string) []sseEvent {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/runs/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestRunRegisteredWorkflow(t *testing.T) {
+	s, srv := testServer(t)
+	s.RegisterWorkflow("echo", singleTask("echo", map[string]interface{}{"value": "default"}))
+
+	var workflows map[string][]string
+	request(t, http.MethodGet, srv.URL+"/workflows", "", &workflows)
+	if names := workflows["workflows"]; len(names) != 1 || names[0] != "echo" {
+		t.Errorf("workflows = %v", workflows)
+	}
+
+	var started RunInfo
+	status := request(t, http.MethodPost, srv.URL+"/workflows/echo/runs", `{"inputs": {"t": {"value": 42}}}`, &started)
+	if status != http.StatusAccepted || started.Status != StatusRunning && started.Status != StatusSucceeded {
+		t.Fatalf("start = %d %+v", status, started)
+	}
+	events := readEvents(t, srv, started.ID)
+	if last := events[len(events)-1]; last.name != "done" || !strings.Contains(last.data, `"status":"succeeded"`) {
+		t.Errorf("last event = %+v, want done with the run's status", last)
+	}
+
+	var info RunInfo
+	request(t, http.MethodGet, srv.URL+"/runs/"+started.ID, "", &info)
+	if info.Status != StatusSucceeded || info.Results["t"] != 42.0 || info.Usage == nil || info.FinishedAt == nil {
+		t.Errorf("run = %+v, want the overridden input as the result", info)
+	}
+
+	if status := request(t, http.MethodPost, srv.URL+"/workflows/missing/runs", "", nil); status != http.StatusNotFound {
+		t.Errorf("starting an unknown workflow = %d, want 404", status)
+	}
+	if status := request(t, http.MethodPost, srv.URL+"/workflows/echo/runs", `{"inputs": {"x": {"value": 1}}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("inputs for an unknown task = %d, want 400", status)
+	}
+}
+
+func TestRunPostedWorkflowStreamsTokens(t *testing.T) {
+	_, srv := testServer(t)
+	workflow := `
+tasks:
+  - id: t
+    tool: stream
+    inputs:
+      words: ["Hello", ", world"]
+agents:
+  - id: a
+    tasks: [t]
+`
+	var started RunInfo
+	if status := request(t, http.MethodPost, srv.URL+"/runs", workflow, &started); status != http.StatusAccepted {
+		t.Fatalf("POST /runs = %d", status)
+	}
+
+	var names []string
+	var text string
+	for _, event := range readEvents(t, srv, started.ID) {
+		names = append(names, event.name)
+		if event.name == "token" {
+			var e Event
+			json.Unmarshal([]byte(event.data), &e)
+			text += e.Chunk
+		}
+	}
+	if text != "Hello, world" {
+		t.Errorf("tokens = %q, want the streamed text", text)
+	}
+	for _, want := range []string{string(aicraft.WorkflowStarted), string(aicraft.TaskSucceeded), "done"} {
+		if !strings.Contains(strings.Join(names, " "), want) {
+			t.Errorf("events %v have no %s", names, want)
+		}
+	}
+
+	var runs map[string][]RunInfo
+	request(t, http.MethodGet, srv.URL+"/runs", "", &runs)
+	if len(runs["runs"]) != 1 || runs["runs"][0].ID != started.ID {
+		t.Errorf("runs = %+v", runs)
+	}
+
+	if status := request(t, http.MethodPost, srv.URL+"/runs", "tasks:\n  - id: t\n    tool: stream\n    retry: 1\n", nil); status != http.StatusBadRequest {
+		t.Errorf("posting a workflow with an unknown field = %d, want 400", status)
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	s, srv := testServer(t)
+	run, err := s.Start("", singleTask("block", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info RunInfo
+	if status := request(t, http.MethodDelete, srv.URL+"/runs/"+run.ID, "", &info); status != http.StatusAccepted {
+		t.Errorf("DELETE = %d, want 202", status)
+	}
+	readEvents(t, srv, run.ID)
+	if info := run.Info(false); info.Status != StatusCancelled {
+		t.Errorf("status = %s, want cancelled", info.Status)
+	}
+	if status := request(t, http.MethodGet, srv.URL+"/runs/missing", "", nil); status != http.StatusNotFound {
+		t.Errorf("GET of an unknown run = %d, want 404", status)
+	}
+}
+
+func TestStartRejectsBaseURL(t *testing.T) {
+	s, _ := testServer(t)
+	config := singleTask("echo", map[string]interface{}{"base_url": "https://example.com"})
+	if _, err := s.Start("", config, nil); err == nil || !strings.Contains(err.Error(), "base_url") {
+		t.Errorf("Start() = %v, want base_url rejected", err)
+	}
+	if _, err := s.Start("", singleTask("echo", nil), map[string]map[string]interface{}{"t": {"base_url": "https://example.com"}}); err == nil {
+		t.Error("Start() accepted base_url as an override")
+	}
+
+	s.AllowBaseURL = true
+	if _, err := s.Start("", config, nil); err != nil {
+		t.Errorf("Start() with AllowBaseURL = %v", err)
+	}
+}
+
+func TestStartRejectsBaseURLFromOtherTasks(t *testing.T) {
+	_, srv := testServer(t)
+	for _, input := range []string{"base_url", "api_key"} {
+		workflow := "tasks:\n  - id: src\n    tool: echo\n    inputs: {value: http://evil.example}\n" +
+			"  - id: t\n    tool: echo\n    inputs_from: {" + input + ": src}\nagents:\n  - id: a\n    tasks: [src, t]\n"
+		var body map[string]string
+		if status := request(t, http.MethodPost, srv.URL+"/runs", workflow, &body); status != http.StatusBadRequest || !strings.Contains(body["error"], input) {
+			t.Errorf("posting a workflow taking %s from another task = %d %v, want 400", input, status, body)
+		}
+	}
+}
+
+func TestRunsRejectBaseURLFromSubworkflows(t *testing.T) {
+	s, srv := testServer(t)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the run sent a request to %s", r.URL)
+	}))
+	defer provider.Close()
+	s.RegisterWorkflow("ask", aicraft.WorkflowConfig{
+		Tasks:   []aicraft.TaskConfig{{ID: "t", ToolID: "openai_content_generator", Inputs: map[string]interface{}{"api_key": "sk-test", "query": "hi", "images": "https://images.test/chart.png"}}},
+		Agents:  []aicraft.AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+		Inputs:  []aicraft.WorkflowInput{{Name: "url", To: []string{"t.base_url"}}},
+		Outputs: map[string]string{"answer": "t"},
+	})
+
+	workflow := "tasks:\n  - id: call\n    tool: ask\n    inputs: {url: " + provider.URL + "}\nagents:\n  - id: a\n    tasks: [call]\n"
+	var started RunInfo
+	if status := request(t, http.MethodPost, srv.URL+"/runs", workflow, &started); status != http.StatusAccepted {
+		t.Fatalf("POST /runs = %d", status)
+	}
+	readEvents(t, srv, started.ID)
+	if info := s.Run(started.ID).Info(false); info.Status != StatusFailed || !strings.Contains(info.Error, "base_url") {
+		t.Errorf("run = %+v, want base_url rejected in the sub-workflow", info)
+	}
+}
+
+func TestEvictFinishedRuns(t *testing.T) {
+	s := New()
+	s.MaxFinishedRuns = 2
+	s.FinishedRunTTL = time.Hour
+	now := time.Now()
+	for id, finished := range map[string]time.Time{
+		"expired": now.Add(-2 * time.Hour),
+		"oldest":  now.Add(-3 * time.Minute),
+		"older":   now.Add(-2 * time.Minute),
+		"newest":  now.Add(-time.Minute),
+	} {
+		s.runs[id] = &Run{ID: id, status: StatusSucceeded, finished: finished}
+	}
+	s.runs["running"] = &Run{ID: "running", status: StatusRunning}
+
+	s.mu.Lock()
+	s.evict()
+	s.mu.Unlock()
+	for _, id := range []string{"running", "older", "newest"} {
+		if s.runs[id] == nil {
+			t.Errorf("run %s was evicted", id)
+		}
+	}
+	if len(s.runs) != 3 {
+		t.Errorf("kept %d runs, want 3", len(s.runs))
+	}
+}
+
+func TestShutdownCancelsRuns(t *testing.T) {
+	s, _ := testServer(t)
+	run, err := s.Start("", singleTask("block", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v, want the deadline", err)
+	}
+	if status := run.Info(false).Status; status != StatusCancelled {
+		t.Errorf("status = %s, want cancelled", status)
+	}
+	if _, err := s.Start("", singleTask("echo", nil), nil); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Start() after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestRegisteredSubworkflows(t *testing.T) {
+	s, srv := testServer(t)
+	s.RegisterWorkflow("shout", aicraft.WorkflowConfig{
+		Tasks:   []aicraft.TaskConfig{{ID: "t", ToolID: "echo"}},
+		Agents:  []aicraft.AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+		Inputs:  []aicraft.WorkflowInput{{Name: "text", To: []string{"t.value"}}},
+		Outputs: map[string]string{"text": "t"},
+	})
+	s.RegisterWorkflow("plain", singleTask("echo", nil))
+
+	workflow := "tasks:\n  - id: call\n    tool: shout\n    inputs: {text: hi}\nagents:\n  - id: a\n    tasks: [call]\n"
+	var started RunInfo
+	if status := request(t, http.MethodPost, srv.URL+"/runs", workflow, &started); status != http.StatusAccepted {
+		t.Fatalf("POST /runs = %d", status)
+	}
+	readEvents(t, srv, started.ID)
+	var info RunInfo
+	request(t, http.MethodGet, srv.URL+"/runs/"+started.ID, "", &info)
+	if result, _ := info.Results["call"].(map[string]interface{}); result["text"] != "hi" {
+		t.Errorf("run = %+v, want the sub-workflow's output", info)
+	}
+
+	workflow = strings.Replace(workflow, "tool: shout", "tool: plain", 1)
+	if status := request(t, http.MethodPost, srv.URL+"/runs", workflow, nil); status != http.StatusBadRequest {
+		t.Errorf("using a workflow without inputs or outputs as a tool = %d, want 400", status)
+	}
+}
@@ -0,0 +1,476 @@
+package aicraft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRunNotFound is returned by a RunStore that has no run with the
+// requested ID.
+var ErrRunNotFound = errors.New("run not found")
+
+// Status is the state of a run or of one of its tasks.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	// StatusWaiting is the status of an approval task waiting for a
+	// decision.
+	StatusWaiting Status = "waiting"
+)
+
+// RunState is the checkpoint of a workflow run. Iterations holds the
+// iteration every loop agent is in, counting from 1.
+type RunState struct {
+	ID         string                `json:"id"`
+	Status     Status                `json:"status"`
+	Error      string                `json:"error,omitempty"`
+	Tasks      map[string]*TaskState `json:"tasks"`
+	Iterations map[string]int        `json:"iterations,omitempty"`
+	StartedAt  time.Time             `json:"started_at"`
+	UpdatedAt  time.Time             `json:"updated_at"`
+}
+
+// TaskState is the checkpoint of a task. Result holds the JSON encoding of
+// the task's result and ResultType the name of its Go type; Result is empty
+// when the result could not be encoded. Iteration is the iteration of its
+// agent's loop the task last ran in, or 0 outside loops. A waiting approval
+// task has the Approval it waits for and, once decided while the run was
+// not executing, the Decision.
+type TaskState struct {
+	Status     Status          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	Iteration  int             `json:"iteration,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	ResultType string          `json:"result_type,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Usage      TokenUsage      `json:"usage"`
+	Approval   *Approval       `json:"approval,omitempty"`
+	Decision   *Decision       `json:"decision,omitempty"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// RunStore persists run checkpoints. The Manager saves the whole state of a
+// run whenever one of its tasks starts or finishes.
+type RunStore interface {
+	Save(state *RunState) error
+	Load(runID string) (*RunState, error)
+}
+
+// MemoryStore keeps checkpoints in memory, so runs can be resumed within
+// one process.
+type MemoryStore struct {
+	mu   sync.Mutex
+	runs map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{runs: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Save(state *RunState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[state.ID] = data
+	return nil
+}
+
+func (s *MemoryStore) Load(runID string) (*RunState, error) {
+	s.mu.Lock()
+	data, ok := s.runs[runID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	var state RunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// DirStore keeps every run's checkpoint in a JSON file named after the run
+// in a directory.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a store in dir, creating it if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) path(runID string) (string, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || strings.HasPrefix(runID, ".") {
+		return "", fmt.Errorf("invalid run ID %q", runID)
+	}
+	return filepath.Join(s.dir, runID+".json"), nil
+}
+
+func (s *DirStore) Save(state *RunState) error {
+	path, err := s.path(state.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *DirStore) Load(runID string) (*RunState, error) {
+	path, err := s.path(runID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state RunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode run %s: %w", runID, err)
+	}
+	return &state, nil
+}
+
+var resultTypes = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}{types: make(map[string]reflect.Type)}
+
+// RegisterResultType lets resumed runs restore task results of the type of
+// value. Results of unregistered types are restored as the generic values
+// encoding/json decodes into. The result types of the predefined tools are
+// registered.
+func RegisterResultType(value interface{}) {
+	t := reflect.TypeOf(value)
+	resultTypes.Lock()
+	defer resultTypes.Unlock()
+	resultTypes.types[t.String()] = t
+}
+
+func init() {
+	for _, value := range []interface{}{
+		"",
+		[]float64(nil),
+		[][]float64(nil),
+		[]GeneratedImage(nil),
+		[]PDFImage(nil),
+		Transcription{},
+		SpeechResult{},
+		[]DiagramItem(nil),
+		DocumentWithFigures{},
+	} {
+		RegisterResultType(value)
+	}
+}
+
+func encodeResult(result interface{}) (string, json.RawMessage, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", nil, err
+	}
+	if result == nil {
+		return "", data, nil
+	}
+	return reflect.TypeOf(result).String(), data, nil
+}
+
+func decodeResult(typeName string, data json.RawMessage) (interface{}, error) {
+	resultTypes.RLock()
+	t, ok := resultTypes.types[typeName]
+	resultTypes.RUnlock()
+	if !ok {
+		var result interface{}
+		err := json.Unmarshal(data, &result)
+		return result, err
+	}
+	value := reflect.New(t)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
+
+// checkpoint records the progress of a run in a RunStore.
+type checkpoint struct {
+	store RunStore
+	mu    sync.Mutex
+	state *RunState
+	// done holds the tasks that succeeded in the run being resumed and
+	// decisions the approvals its waiting tasks got.
+	done      map[string]*TaskState
+	decisions map[string]Decision
+}
+
+// startCheckpoint starts recording run runID in Store, continuing state, the
+// checkpoint of the run being resumed, if it is not nil. It returns nil
+// without a Store.
+func (m *Manager) startCheckpoint(runID string, state *RunState) (*checkpoint, error) {
+	if m.Store == nil {
+		return nil, nil
+	}
+	c := &checkpoint{store: m.Store, state: state, done: make(map[string]*TaskState), decisions: make(map[string]Decision)}
+	if state == nil {
+		c.state = &RunState{ID: runID, Tasks: make(map[string]*TaskState), StartedAt: time.Now()}
+	}
+	for taskID, task := range c.state.Tasks {
+		if task.Status == StatusSucceeded && task.Result != nil {
+			c.done[taskID] = task
+		}
+		if task.Status == StatusWaiting && task.Decision != nil {
+			c.decisions[taskID] = *task.Decision
+		}
+	}
+	c.state.Status = StatusRunning
+	c.state.Error = ""
+	return c, c.save()
+}
+
+// save stores the state. c.mu must be held or the run not yet started.
+func (c *checkpoint) save() error {
+	c.state.UpdatedAt = time.Now()
+	if err := c.store.Save(c.state); err != nil {
+		return fmt.Errorf("failed to checkpoint run %s: %w", c.state.ID, err)
+	}
+	return nil
+}
+
+// restore returns the result the task had when it succeeded in the resumed
+// run, if it succeeded in the given iteration of its loop.
+func (c *checkpoint) restore(taskID string, iteration int) (interface{}, bool, error) {
+	if c == nil {
+		return nil, false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	task, ok := c.done[taskID]
+	if !ok || task.Iteration != iteration {
+		return nil, false, nil
+	}
+	// Tasks in a loop run again in the following iterations.
+	delete(c.done, taskID)
+	result, err := decodeResult(task.ResultType, task.Result)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to restore the result of task %s: %w", taskID, err)
+	}
+	return result, true, nil
+}
+
+// resumeLoop returns the iteration the loop of agent was in when the
+// resumed run stopped, or 1, and gives the agent's tasks the results they
+// had in the iteration before it, which the next iteration may use.
+func (c *checkpoint) resumeLoop(agent *Agent) (int, error) {
+	if c == nil {
+		return 1, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	iteration := c.state.Iterations[agent.ID]
+	if iteration <= 1 {
+		return 1, nil
+	}
+	for _, task := range agent.Tasks {
+		state, ok := c.done[task.ID]
+		if !ok || state.Iteration != iteration-1 {
+			continue
+		}
+		delete(c.done, task.ID)
+		result, err := decodeResult(state.ResultType, state.Result)
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore the result of task %s: %w", task.ID, err)
+		}
+		task.Result = result
+	}
+	return iteration, nil
+}
+
+// loopIteration records that the loop of the agent is in iteration.
+func (c *checkpoint) loopIteration(agentID string, iteration int) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Iterations[agentID] == iteration {
+		return nil
+	}
+	if c.state.Iterations == nil {
+		c.state.Iterations = make(map[string]int)
+	}
+	c.state.Iterations[agentID] = iteration
+	return c.save()
+}
+
+func (c *checkpoint) taskStarted(task *Task, iteration, attempt int) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Tasks[task.ID] = &TaskState{Status: StatusRunning, Attempts: attempt, Iteration: iteration, UpdatedAt: time.Now()}
+	return c.save()
+}
+
+// taskFinished records the outcome of the task's last attempt. A result
+// that cannot be encoded is left out, so the task runs again on resume. A
+// task whose run was stopped while it waited for approval keeps waiting.
+func (c *checkpoint) taskFinished(task *Task, iteration, attempt int, err error) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.state.Tasks[task.ID]; ok && previous.Status == StatusWaiting &&
+		(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return nil
+	}
+	state := &TaskState{Status: StatusSucceeded, Attempts: attempt, Iteration: iteration, Usage: task.Usage(), UpdatedAt: time.Now()}
+	if err != nil {
+		state.Status = StatusFailed
+		state.Error = err.Error()
+	} else if typeName, result, encodeErr := encodeResult(task.Result); encodeErr == nil {
+		state.ResultType = typeName
+		state.Result = result
+	} else {
+		state.Error = fmt.Sprintf("result not stored: %v", encodeErr)
+	}
+	c.state.Tasks[task.ID] = state
+	return c.save()
+}
+
+// awaitApproval records that the task waits for approval.
+func (c *checkpoint) awaitApproval(taskID string, approval Approval) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := &TaskState{Status: StatusWaiting, Approval: &approval, UpdatedAt: time.Now()}
+	if previous, ok := c.state.Tasks[taskID]; ok {
+		state.Attempts = previous.Attempts
+		state.Iteration = previous.Iteration
+	}
+	c.state.Tasks[taskID] = state
+	return c.save()
+}
+
+// forget removes the task from the checkpoint.
+func (c *checkpoint) forget(taskID string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.state.Tasks, taskID)
+	return c.save()
+}
+
+// decision returns the decision made on the approval the task waited for in
+// the run being resumed.
+func (c *checkpoint) decision(taskID string) (Decision, bool) {
+	if c == nil {
+		return Decision{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	decision, ok := c.decisions[taskID]
+	delete(c.decisions, taskID)
+	return decision, ok
+}
+
+// finish records that the run ended with err.
+func (c *checkpoint) finish(err error) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Status = StatusSucceeded
+	if err != nil {
+		c.state.Status = StatusFailed
+		c.state.Error = err.Error()
+	}
+	return c.save()
+}
+
+// ResumeRun returns a run that continues run runID from Store. Tasks that
+// succeeded in that run are not executed again; their results are restored
+// from the store. The workflow must be defined as it was for the original
+// run.
+func (m *Manager) ResumeRun(runID string) (*Run, error) {
+	if m.Store == nil {
+		return nil, errors.New("cannot resume without a run store")
+	}
+	state, err := m.Store.Load(runID)
+	if err != nil {
+		return nil, err
+	}
+	run, err := m.NewRun()
+	if err != nil {
+		return nil, err
+	}
+	for taskID := range state.Tasks {
+		// Approvals in sub-workflows are stored under the path of their task.
+		topTask, _, _ := strings.Cut(taskID, "/")
+		if _, ok := run.Tasks[topTask]; !ok {
+			return nil, fmt.Errorf("run %s has task %s, which the workflow does not define", runID, taskID)
+		}
+	}
+	run.ID = runID
+	run.resume = state
+	return run, nil
+}
+
+// Resume executes the run returned by ResumeRun like ExecuteWorkflow.
+func (m *Manager) Resume(runID string) error {
+	return m.ResumeContext(context.Background(), runID)
+}
+
+// ResumeContext is Resume with a context, like ExecuteWorkflowContext.
+func (m *Manager) ResumeContext(ctx context.Context, runID string) error {
+	run, err := m.ResumeRun(runID)
+	if err != nil {
+		return err
+	}
+	err = run.Execute(ctx)
+	m.adopt(run)
+	return err
+}
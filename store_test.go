@@ -0,0 +1,146 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testResult struct {
+	Name  string
+	Count int
+}
+
+func TestStoresRoundTrip(t *testing.T) {
+	dir, err := NewDirStore(filepath.Join(t.TempDir(), "runs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]RunStore{"memory": NewMemoryStore(), "dir": dir} {
+		state := &RunState{ID: "run-1", Status: StatusFailed, Tasks: map[string]*TaskState{
+			"t": {Status: StatusSucceeded, Attempts: 2, Result: []byte(`"text"`), ResultType: "string"},
+		}}
+		if err := store.Save(state); err != nil {
+			t.Fatalf("%s: Save() = %v", name, err)
+		}
+		loaded, err := store.Load("run-1")
+		if err != nil {
+			t.Fatalf("%s: Load() = %v", name, err)
+		}
+		if loaded.Status != StatusFailed || loaded.Tasks["t"].Attempts != 2 || string(loaded.Tasks["t"].Result) != `"text"` {
+			t.Errorf("%s: loaded %+v", name, loaded)
+		}
+		if _, err := store.Load("missing"); !errors.Is(err, ErrRunNotFound) {
+			t.Errorf("%s: Load of a missing run = %v, want ErrRunNotFound", name, err)
+		}
+	}
+
+	for _, id := range []string{"", "../escape", `a\b`, ".hidden"} {
+		if err := dir.Save(&RunState{ID: id}); err == nil {
+			t.Errorf("DirStore saved run ID %q", id)
+		}
+	}
+}
+
+func TestResultTypesRoundTrip(t *testing.T) {
+	RegisterResultType(testResult{})
+	for _, result := range []interface{}{
+		"text",
+		[]float64{0.5, 1},
+		[]DiagramItem{{Description: "a chart"}},
+		testResult{Name: "n", Count: 3},
+	} {
+		typeName, data, err := encodeResult(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeResult(typeName, data)
+		if err != nil || !reflect.DeepEqual(decoded, result) {
+			t.Errorf("round trip of %#v = %#v, %v", result, decoded, err)
+		}
+	}
+
+	typeName, data, _ := encodeResult(map[string]int{"n": 1})
+	decoded, _ := decodeResult(typeName, data)
+	if !reflect.DeepEqual(decoded, map[string]interface{}{"n": 1.0}) {
+		t.Errorf("unregistered type decoded as %#v, want the generic JSON value", decoded)
+	}
+}
+
+func TestResumeSkipsCompletedTasks(t *testing.T) {
+	fetches, failures := 0, 1
+	fetch := funcTool("fetch", func(inputs map[string]interface{}) (interface{}, error) {
+		fetches++
+		return []DiagramItem{{Description: "fetched"}}, nil
+	})
+	summarize := funcTool("summarize", func(inputs map[string]interface{}) (interface{}, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("provider unavailable")
+		}
+		items, ok := inputs["items"].([]DiagramItem)
+		if !ok {
+			return nil, errors.New("items were not restored with their type")
+		}
+		return "summary of " + items[0].Description, nil
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "fetch", ToolID: "fetch"},
+			{ID: "summarize", ToolID: "summarize", InputsFrom: map[string]string{"items": "fetch"}},
+		},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"fetch"}},
+			{ID: "b", DependsOn: []string{"a"}, Tasks: []string{"summarize"}},
+		},
+	}, fetch, summarize)
+	store := NewMemoryStore()
+	m.Store = store
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err == nil {
+		t.Fatal("want the first run to fail")
+	}
+	state, err := store.Load(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StatusFailed || state.Tasks["fetch"].Status != StatusSucceeded || state.Tasks["summarize"].Status != StatusFailed {
+		t.Fatalf("checkpoint = %+v", state)
+	}
+
+	if err := m.Resume(run.ID); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 {
+		t.Errorf("fetch ran %d times, want it restored on resume", fetches)
+	}
+	if result := m.Tasks["summarize"].Result; result != "summary of fetched" {
+		t.Errorf("summarize result = %#v", result)
+	}
+	state, _ = store.Load(run.ID)
+	if state.Status != StatusSucceeded || state.Error != "" {
+		t.Errorf("resumed checkpoint status = %s, error %q", state.Status, state.Error)
+	}
+}
+
+func TestResumeRunErrors(t *testing.T) {
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "echo"}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}, echoTool)
+	if _, err := m.ResumeRun("run-1"); err == nil {
+		t.Error("ResumeRun without a store succeeded")
+	}
+
+	m.Store = NewMemoryStore()
+	if _, err := m.ResumeRun("run-1"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("ResumeRun of an unknown run = %v, want ErrRunNotFound", err)
+	}
+	m.Store.Save(&RunState{ID: "run-1", Tasks: map[string]*TaskState{"other": {Status: StatusSucceeded}}})
+	if _, err := m.ResumeRun("run-1"); err == nil {
+		t.Error("ResumeRun accepted a checkpoint of another workflow")
+	}
+}
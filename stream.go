@@ -0,0 +1,222 @@
+package aicraft
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type StreamEventType string
+
+const (
+	StreamDelta  StreamEventType = "delta"
+	StreamFinish StreamEventType = "finish"
+	StreamUsage  StreamEventType = "usage"
+	StreamError  StreamEventType = "error"
+)
+
+// ErrStreamTruncated is reported when a stream ends without the provider
+// marking it as complete.
+var ErrStreamTruncated = errors.New("stream ended before completion")
+
+// StreamEvent is sent on the streams of the chat tools. A stream that
+// completes normally contains a StreamFinish event; one that fails ends with
+// a StreamError event carrying Err.
+type StreamEvent struct {
+	Type         StreamEventType
+	Delta        string
+	FinishReason string
+	Usage        *Usage
+	Err          error
+}
+
+// StreamHub fans a tool's stream out to any number of subscribers and
+// accumulates the streamed text. Subscribers that join late first receive
+// the chunks they missed.
+type StreamHub struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	chunks []interface{}
+	text   strings.Builder
+	closed bool
+	err    error
+	done   chan struct{}
+}
+
+// NewStreamHub starts consuming source. onDone, if not nil, is called with
+// the accumulated text once source is closed and before Done is closed.
+func NewStreamHub(source <-chan interface{}, onDone func(text string)) *StreamHub {
+	h := &StreamHub{done: make(chan struct{})}
+	h.cond = sync.NewCond(&h.mu)
+
+	go func() {
+		for chunk := range source {
+			h.mu.Lock()
+			h.chunks = append(h.chunks, chunk)
+			h.text.WriteString(chunkText(chunk))
+			if event, ok := chunk.(StreamEvent); ok && event.Type == StreamError && h.err == nil {
+				h.err = event.Err
+			}
+			h.mu.Unlock()
+			h.cond.Broadcast()
+		}
+
+		h.mu.Lock()
+		h.closed = true
+		text := h.text.String()
+		h.mu.Unlock()
+		h.cond.Broadcast()
+
+		if onDone != nil {
+			onDone(text)
+		}
+		close(h.done)
+	}()
+
+	return h
+}
+
+// Subscribe returns a channel that receives every chunk of the stream from
+// the beginning and is closed when the stream ends. The channel must be
+// drained.
+func (h *StreamHub) Subscribe() <-chan interface{} {
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			h.mu.Lock()
+			for i >= len(h.chunks) && !h.closed {
+				h.cond.Wait()
+			}
+			if i >= len(h.chunks) {
+				h.mu.Unlock()
+				return
+			}
+			chunk := h.chunks[i]
+			h.mu.Unlock()
+			ch <- chunk
+		}
+	}()
+	return ch
+}
+
+// Done is closed once the stream has ended and the text is complete.
+func (h *StreamHub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the stream has ended and returns the full text.
+func (h *StreamHub) Wait() string {
+	<-h.done
+	return h.Text()
+}
+
+// Err returns the error the stream ended with, if any. It is only final
+// once Done is closed.
+func (h *StreamHub) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Text returns the text received so far.
+func (h *StreamHub) Text() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.text.String()
+}
+
+func chunkText(chunk interface{}) string {
+	switch c := chunk.(type) {
+	case string:
+		return c
+	case StreamEvent:
+		return c.Delta
+	}
+	return ""
+}
+
+// readChatStream reads a chat completions server-sent event stream from body
+// and sends its content as StreamEvents on events, closing both when done.
+// Errors are recorded on the span in ctx and usage on the span and meter.
+// It returns the usage reported by the stream, if any.
+func readChatStream(ctx context.Context, model string, body io.ReadCloser, events chan<- interface{}) (usage *Usage) {
+	defer body.Close()
+	defer close(events)
+
+	fail := func(err error) {
+		spanError(trace.SpanFromContext(ctx), err)
+		events <- StreamEvent{Type: StreamError, Err: err}
+	}
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = ErrStreamTruncated
+			}
+			fail(fmt.Errorf("failed to read stream: %w", err))
+			return usage
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "[DONE]" {
+			return usage
+		}
+
+		var streamResponse struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+			} `json:"error"`
+		}
+
+		if err := json.Unmarshal([]byte(line), &streamResponse); err != nil {
+			fail(fmt.Errorf("failed to decode stream chunk: %w", err))
+			return usage
+		}
+
+		if streamResponse.Error != nil {
+			fail(&APIError{
+				Type:    streamResponse.Error.Type,
+				Code:    streamResponse.Error.Code,
+				Message: streamResponse.Error.Message,
+			})
+			return usage
+		}
+
+		for _, choice := range streamResponse.Choices {
+			if choice.Delta.Content != "" {
+				events <- StreamEvent{Type: StreamDelta, Delta: choice.Delta.Content}
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				events <- StreamEvent{Type: StreamFinish, FinishReason: *choice.FinishReason}
+			}
+		}
+		if streamResponse.Usage != nil {
+			usage = streamResponse.Usage
+			recordUsage(ctx, model, false, usage)
+			events <- StreamEvent{Type: StreamUsage, Usage: usage}
+		}
+	}
+}
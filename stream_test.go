@@ -0,0 +1,162 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// sendChunks returns a channel that yields chunks and is then closed.
+func sendChunks(chunks ...interface{}) <-chan interface{} {
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for _, chunk := range chunks {
+			ch <- chunk
+		}
+	}()
+	return ch
+}
+
+func TestStreamHubFansOut(t *testing.T) {
+	source := make(chan interface{})
+	var finished string
+	hub := NewStreamHub(source, func(text string) { finished = text })
+
+	early := hub.Subscribe()
+	source <- "Hello"
+	source <- StreamEvent{Type: StreamDelta, Delta: ", world"}
+	late := hub.Subscribe()
+	close(source)
+
+	for name, sub := range map[string]<-chan interface{}{"early": early, "late": late} {
+		var text string
+		for chunk := range sub {
+			text += chunkText(chunk)
+		}
+		if text != "Hello, world" {
+			t.Errorf("%s subscriber got %q", name, text)
+		}
+	}
+	if text := hub.Wait(); text != "Hello, world" {
+		t.Errorf("Wait() = %q", text)
+	}
+	if finished != "Hello, world" {
+		t.Errorf("onDone got %q before Done was closed", finished)
+	}
+	if text := chunkText(<-hub.Subscribe()); text != "Hello" {
+		t.Errorf("a subscriber after the end got %q first", text)
+	}
+}
+
+func TestTaskKeepsStreamedText(t *testing.T) {
+	tool := &Tool{ID: "stream", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		return nil, sendChunks("streamed ", "text"), nil
+	}}
+	task := NewTask("t", "T", tool, nil)
+
+	if err := task.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if task.Result != "streamed text" {
+		t.Errorf("Result = %#v, want the streamed text", task.Result)
+	}
+}
+
+// streamFrom serves body as a chat completions stream and returns the
+// events the content generator streams for it.
+func streamFrom(t *testing.T, body string) []StreamEvent {
+	t.Helper()
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, body)
+		},
+	})
+	_, stream, err := OpenAIContentGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"query":        "hi",
+		"context":      "none",
+		"chunkSize":    100,
+		"chunkOverlap": 0,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []StreamEvent
+	for item := range stream {
+		events = append(events, item.(StreamEvent))
+	}
+	return events
+}
+
+func TestChatStreamCompletes(t *testing.T) {
+	events := streamFrom(t, `data: {"choices": [{"delta": {"content": "Hi"}}]}
+
+data: {"choices": [{"delta": {}, "finish_reason": "stop"}]}
+
+data: {"choices": [], "usage": {"prompt_tokens": 3, "completion_tokens": 1, "total_tokens": 4}}
+
+data: [DONE]
+
+`)
+	var types []StreamEventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	if !reflect.DeepEqual(types, []StreamEventType{StreamDelta, StreamFinish, StreamUsage}) {
+		t.Fatalf("event types = %v", types)
+	}
+	if events[1].FinishReason != "stop" || events[2].Usage.TotalTokens != 4 {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestChatStreamTruncated(t *testing.T) {
+	events := streamFrom(t, `data: {"choices": [{"delta": {"content": "Hal"}}]}
+
+`)
+	last := events[len(events)-1]
+	if last.Type != StreamError || !errors.Is(last.Err, ErrStreamTruncated) {
+		t.Errorf("last event = %+v, want a truncation error", last)
+	}
+	if events[0].Delta != "Hal" {
+		t.Errorf("the text before the truncation was lost: %+v", events)
+	}
+}
+
+func TestChatStreamErrors(t *testing.T) {
+	events := streamFrom(t, `data: {"error": {"message": "overloaded", "type": "server_error", "code": "overloaded"}}
+
+`)
+	var apiErr *APIError
+	if len(events) != 1 || !errors.As(events[0].Err, &apiErr) || apiErr.Message != "overloaded" {
+		t.Errorf("events = %+v, want an API error", events)
+	}
+
+	events = streamFrom(t, "data: {not json\n\n")
+	if len(events) != 1 || events[0].Type != StreamError {
+		t.Errorf("events = %+v, want a decoding error", events)
+	}
+}
+
+func TestTaskWaitReportsStreamErrors(t *testing.T) {
+	tool := &Tool{ID: "stream", ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		return nil, sendChunks(StreamEvent{Type: StreamDelta, Delta: "partial"}, StreamEvent{Type: StreamError, Err: ErrStreamTruncated}), nil
+	}}
+	task := NewTask("t", "T", tool, nil)
+	if err := task.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(); !errors.Is(err, ErrStreamTruncated) {
+		t.Errorf("Wait() = %v, want ErrStreamTruncated", err)
+	}
+	if task.Stream.Text() != "partial" {
+		t.Errorf("Text() = %q", task.Stream.Text())
+	}
+}
@@ -0,0 +1,235 @@
+package aicraft
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WorkflowInput is an input of a sub-workflow. Its value is set as the
+// task inputs listed in To, written as task_id.input.
+type WorkflowInput struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        string   `yaml:"type" json:"type,omitempty"`
+	Required    bool     `yaml:"required" json:"required,omitempty"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+	To          []string `yaml:"to" json:"to"`
+}
+
+// RegisterWorkflow registers config as a tool with id, so tasks can run it
+// as a sub-workflow. The tool's inputs are config.Inputs and its result maps
+// the names of config.Outputs to the results of their tasks; other tasks
+// take a single output with InputsFrom as task_id.output. Every execution is
+// a run of its own, with the tasks and agents of config kept apart from the
+// caller's, that executes like Run.Execute and publishes its events as
+// events of the calling run, with the calling task in Event.Parent.
+func (m *Manager) RegisterWorkflow(id, name string, config WorkflowConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.Tools[id]; ok {
+		return fmt.Errorf("tool %s: %w", id, ErrDuplicateID)
+	}
+	if err := m.validateWorkflow(config, nil, nil); err != nil {
+		return fmt.Errorf("workflow %s: %w", id, err)
+	}
+	if err := checkWorkflowInterface(config); err != nil {
+		return fmt.Errorf("workflow %s: %w", id, err)
+	}
+
+	definitions := &Manager{
+		Agents: make(map[string]*Agent),
+		Tasks:  make(map[string]*Task),
+		Tools:  m.Tools,
+	}
+	definitions.defineWorkflow(config)
+	m.Tools[id] = m.workflowTool(id, name, config, definitions.Agents)
+	return nil
+}
+
+// checkWorkflowInterface checks that the inputs and outputs of config refer
+// to tasks its agents run.
+func checkWorkflowInterface(config WorkflowConfig) error {
+	run := make(map[string]bool)
+	for _, agent := range config.Agents {
+		for _, taskID := range agent.Tasks {
+			run[taskID] = true
+		}
+	}
+	for _, input := range config.Inputs {
+		if input.Name == "" {
+			return fmt.Errorf("an input needs a name")
+		}
+		for _, target := range input.To {
+			taskID, name, _ := strings.Cut(target, ".")
+			if name == "" {
+				return fmt.Errorf("input %s sets '%s', not task_id.input", input.Name, target)
+			}
+			if !run[taskID] {
+				return fmt.Errorf("input %s sets an input of %w %s", input.Name, ErrTaskNotFound, taskID)
+			}
+		}
+	}
+	for output, taskID := range config.Outputs {
+		if !run[taskID] {
+			return fmt.Errorf("output %s is the result of %w %s", output, ErrTaskNotFound, taskID)
+		}
+	}
+	return nil
+}
+
+// workflowTool returns the tool that runs the registered agents of a
+// sub-workflow.
+func (m *Manager) workflowTool(id, name string, config WorkflowConfig, agents map[string]*Agent) *Tool {
+	inputs := make([]ToolInput, len(config.Inputs))
+	for i, input := range config.Inputs {
+		inputs[i] = ToolInput{Name: input.Name, Type: input.Type, Required: input.Required, Description: input.Description}
+	}
+	return &Tool{
+		ID:     id,
+		Name:   name,
+		Inputs: inputs,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			run, err := newRun(m, agents, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := run.setInputs(config.Inputs, inputs); err != nil {
+				return nil, nil, err
+			}
+			if scope, ok := ctx.Value(runTaskKey{}).(runTask); ok {
+				run.parent, run.parentTask = scope.run, scope.taskID
+			}
+
+			if err := run.executeNested(ctx, id); err != nil {
+				return nil, nil, err
+			}
+			outputs := make(map[string]interface{}, len(config.Outputs))
+			for output, taskID := range config.Outputs {
+				outputs[output] = run.Tasks[taskID].Result
+			}
+			return outputs, nil, nil
+		},
+		// The estimate of a sub-workflow is the sum of the estimates of
+		// its tasks.
+		Estimate: func(inputs map[string]interface{}, prices PriceTable) UsageEstimate {
+			run, err := newRun(m, agents, nil)
+			if err != nil {
+				return UsageEstimate{Partial: true}
+			}
+			var estimate UsageEstimate
+			if err := run.setInputs(config.Inputs, inputs); err != nil {
+				estimate.Partial = true
+			}
+			for _, agent := range run.Agents {
+				for _, task := range agent.Tasks {
+					planned := planTask(task, agent.MaxIterations, prices)
+					if planned.Estimate != nil {
+						estimate.Usage.Add(*planned.Estimate)
+					}
+					estimate.Partial = estimate.Partial || planned.Partial
+				}
+			}
+			return estimate
+		},
+		Placeholder: func(inputs map[string]interface{}) interface{} {
+			outputs := make(map[string]interface{}, len(config.Outputs))
+			for output, taskID := range config.Outputs {
+				outputs[output] = fmt.Sprintf("(result of %s)", taskID)
+			}
+			return outputs
+		},
+	}
+}
+
+// setInputs sets the task inputs of a sub-workflow run from the inputs of
+// its tool.
+func (r *Run) setInputs(workflowInputs []WorkflowInput, inputs map[string]interface{}) error {
+	for _, input := range workflowInputs {
+		value, ok := inputs[input.Name]
+		if !ok {
+			if input.Required {
+				return fmt.Errorf("input '%s' is required", input.Name)
+			}
+			continue
+		}
+		for _, target := range input.To {
+			taskID, name, _ := strings.Cut(target, ".")
+			task := r.Tasks[taskID]
+			if task.Inputs == nil {
+				task.Inputs = make(map[string]interface{})
+			}
+			task.Inputs[name] = value
+		}
+	}
+	return nil
+}
+
+// runTask identifies the task of a run whose tool is executing; it is
+// passed on the context to sub-workflows.
+type runTask struct {
+	run     *Run
+	agentID string
+	taskID  string
+}
+
+type runTaskKey struct{}
+
+// executeNested runs the agents of a sub-workflow one after another. Their
+// usage counts towards the calling task and the budget of the calling run.
+func (r *Run) executeNested(ctx context.Context, workflowID string) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	start := time.Now()
+	ctx, span := r.manager.tracer().Start(ctx, "workflow "+workflowID, trace.WithAttributes(attribute.String("aicraft.workflow.id", workflowID)))
+	defer span.End()
+	var meter usageMeter
+	meter.attach(usageMeterFromContext(ctx))
+	ctx = withUsageMeter(ctx, &meter)
+
+	r.publish(Event{Type: WorkflowStarted})
+	_, err := r.executeAgents(ctx)
+	if err != nil {
+		spanError(span, err)
+	}
+	r.publish(Event{Type: WorkflowFinished, Duration: time.Since(start), Usage: meter.total(), Err: err})
+	return err
+}
+
+// isTaskReference reports whether ref names a task, or an output of one as
+// task_id.output.
+func isTaskReference(ref string, task func(id string) bool) bool {
+	if task(ref) {
+		return true
+	}
+	taskID, _, ok := strings.Cut(ref, ".")
+	return ok && task(taskID)
+}
+
+// outputOf returns the output with name of the result of a sub-workflow
+// task.
+func outputOf(task *Task, name string) (interface{}, error) {
+	outputs, ok := task.Result.(map[string]interface{})
+	if !ok {
+		result, err := normalize(task.Result)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use the result of %s: %v", task.ID, err)
+		}
+		outputs, _ = result.(map[string]interface{})
+	}
+	value, ok := outputs[name]
+	if !ok {
+		names := make([]string, 0, len(outputs))
+		for output := range outputs {
+			names = append(names, output)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("task %s has no output '%s' (outputs: %s)", task.ID, name, strings.Join(names, ", "))
+	}
+	return value, nil
+}
@@ -0,0 +1,140 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// greetingWorkflow greets its 'name' input and returns the greeting as
+// its 'text' output.
+var greetingWorkflow = WorkflowConfig{
+	Tasks:   []TaskConfig{{ID: "greet", ToolID: "greet"}},
+	Agents:  []AgentConfig{{ID: "greeter", Tasks: []string{"greet"}}},
+	Inputs:  []WorkflowInput{{Name: "name", Type: "string", Required: true, To: []string{"greet.name"}}},
+	Outputs: map[string]string{"text": "greet"},
+}
+
+var greetTool = funcTool("greet", func(inputs map[string]interface{}) (interface{}, error) {
+	return "Hello, " + inputs["name"].(string), nil
+})
+
+func TestSubworkflowOutputs(t *testing.T) {
+	m := NewManager()
+	m.RegisterTool(greetTool)
+	m.RegisterTool(echoTool)
+	if err := m.RegisterWorkflow("greeting", "Greeting", greetingWorkflow); err != nil {
+		t.Fatal(err)
+	}
+	if inputs := m.Tools["greeting"].Inputs; len(inputs) != 1 || inputs[0].Name != "name" || !inputs[0].Required {
+		t.Errorf("Inputs = %+v", inputs)
+	}
+	if err := m.InitializeWorkflow(WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "hello", ToolID: "greeting", Inputs: map[string]interface{}{"name": "Ada"}},
+			{ID: "copy", ToolID: "echo", InputsFrom: map[string]string{"value": "hello.text"}},
+		},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"hello"}},
+			{ID: "b", DependsOn: []string{"a"}, Tasks: []string{"copy"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := recordEvents(m)
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result := run.Tasks["hello"].Result; !reflect.DeepEqual(result, map[string]interface{}{"text": "Hello, Ada"}) {
+		t.Errorf("hello = %#v", result)
+	}
+	if result := run.Tasks["copy"].Result; result != "Hello, Ada" {
+		t.Errorf("copy = %#v, want the single output", result)
+	}
+
+	var nested []EventType
+	for _, event := range recorder.events {
+		if event.Parent == "hello" {
+			nested = append(nested, event.Type)
+			if event.RunID != run.ID {
+				t.Errorf("nested event has run ID %q, want the calling run's", event.RunID)
+			}
+		}
+	}
+	want := []EventType{WorkflowStarted, AgentStarted, TaskStarted, TaskSucceeded, AgentFinished, WorkflowFinished}
+	if !reflect.DeepEqual(nested, want) {
+		t.Errorf("events of the sub-workflow = %v, want %v", nested, want)
+	}
+}
+
+func TestNestedSubworkflowEvents(t *testing.T) {
+	m := NewManager()
+	m.RegisterTool(greetTool)
+	if err := m.RegisterWorkflow("inner", "Inner", greetingWorkflow); err != nil {
+		t.Fatal(err)
+	}
+	outer := WorkflowConfig{
+		Tasks:   []TaskConfig{{ID: "call", ToolID: "inner"}},
+		Agents:  []AgentConfig{{ID: "caller", Tasks: []string{"call"}}},
+		Inputs:  []WorkflowInput{{Name: "name", To: []string{"call.name"}}},
+		Outputs: map[string]string{"inner": "call"},
+	}
+	if err := m.RegisterWorkflow("outer", "Outer", outer); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InitializeWorkflow(WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "top", ToolID: "outer", Inputs: map[string]interface{}{"name": "Grace"}}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"top"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := recordEvents(m)
+
+	if err := m.ExecuteWorkflow(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"inner": map[string]interface{}{"text": "Hello, Grace"}}
+	if result := m.Tasks["top"].Result; !reflect.DeepEqual(result, want) {
+		t.Errorf("top = %#v, want %#v", result, want)
+	}
+	found := false
+	for _, event := range recorder.events {
+		if event.Type == TaskSucceeded && event.TaskID == "greet" {
+			found = event.Parent == "top/call"
+		}
+	}
+	if !found {
+		t.Error("the innermost task's event does not have the path top/call as its parent")
+	}
+}
+
+func TestSubworkflowErrors(t *testing.T) {
+	m := NewManager()
+	m.RegisterTool(greetTool)
+	if err := m.RegisterWorkflow("greet", "Greet", greetingWorkflow); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("RegisterWorkflow with a tool's ID = %v, want ErrDuplicateID", err)
+	}
+
+	broken := map[string]func(config *WorkflowConfig){
+		"unknown input task":  func(c *WorkflowConfig) { c.Inputs = []WorkflowInput{{Name: "n", To: []string{"missing.name"}}} },
+		"input without field": func(c *WorkflowConfig) { c.Inputs = []WorkflowInput{{Name: "n", To: []string{"greet"}}} },
+		"unnamed input":       func(c *WorkflowConfig) { c.Inputs = []WorkflowInput{{To: []string{"greet.name"}}} },
+		"unknown output task": func(c *WorkflowConfig) { c.Outputs = map[string]string{"text": "missing"} },
+	}
+	for name, change := range broken {
+		config := greetingWorkflow
+		change(&config)
+		if err := m.RegisterWorkflow("w", "W", config); err == nil {
+			t.Errorf("%s: RegisterWorkflow succeeded", name)
+		}
+	}
+
+	m.RegisterWorkflow("greeting", "Greeting", greetingWorkflow)
+	if _, _, err := m.Tools["greeting"].ExecuteContext(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "input 'name' is required") {
+		t.Errorf("Execute without a required input = %v", err)
+	}
+}
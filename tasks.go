@@ -1,14 +1,37 @@
 package aicraft
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type Task struct {
 	ID     string
 	Name   string
 	Tool   *Tool
 	Inputs map[string]interface{}
-	Result interface{}
-	Stream <-chan interface{}
+	// InputsFrom maps input names to the IDs of tasks whose results are
+	// used as that input. The referenced tasks should belong to agents this
+	// task's agent depends on.
+	InputsFrom map[string]string
+	// Retries is the number of times the Manager re-runs a failed task,
+	// waiting RetryDelay, doubled after every attempt, in between. Rejected
+	// approvals are not retried.
+	Retries    int
+	RetryDelay time.Duration
+	// BypassCache makes the task's requests skip the client's cache.
+	BypassCache bool
+	// ForEach names a list input, typically filled with InputsFrom. The
+	// tool then runs once for every element, with the element as that
+	// input, and Result is the list of their results in the same order.
+	// Parallelism is the number of elements processed at once; 1 when
+	// unset.
+	ForEach     string
+	Parallelism int
+	Result      interface{}
+	Stream      *StreamHub
+	usage       usageMeter
 }
 
 func NewTask(id, name string, tool *Tool, inputs map[string]interface{}) *Task {
@@ -20,16 +43,73 @@ func NewTask(id, name string, tool *Tool, inputs map[string]interface{}) *Task {
 	}
 }
 
-func (t *Task) Execute() error {
+// copy returns a task with the same definition and no result, for a new
+// run.
+func (t *Task) copy() *Task {
+	task := NewTask(t.ID, t.Name, t.Tool, nil)
+	if t.Inputs != nil {
+		task.Inputs = make(map[string]interface{}, len(t.Inputs))
+		for name, value := range t.Inputs {
+			task.Inputs[name] = value
+		}
+	}
+	task.InputsFrom = t.InputsFrom
+	task.Retries = t.Retries
+	task.RetryDelay = t.RetryDelay
+	task.BypassCache = t.BypassCache
+	task.ForEach = t.ForEach
+	task.Parallelism = t.Parallelism
+	return task
+}
+
+func (t *Task) Execute(ctx context.Context) error {
 	if t.Tool == nil {
 		return fmt.Errorf("task %s has no tool assigned", t.Name)
 	}
 
-	result, stream, err := t.Tool.Execute(t.Inputs)
+	t.usage.attach(usageMeterFromContext(ctx))
+	ctx = withUsageMeter(ctx, &t.usage)
+	if t.BypassCache {
+		ctx = withoutCache(ctx)
+	}
+
+	t.Stream = nil
+	if t.ForEach != "" {
+		result, err := t.executeEach(ctx)
+		if err != nil {
+			return err
+		}
+		t.Result = result
+		return nil
+	}
+	result, stream, err := t.Tool.execute(ctx, t.Inputs)
 	if err != nil {
 		return err
 	}
 	t.Result = result
-	t.Stream = stream
+	if stream != nil {
+		t.Stream = NewStreamHub(stream, func(text string) {
+			t.Result = text
+		})
+	}
+	return nil
+}
+
+// Usage returns the tokens the task has used. Usage of a stream is added
+// when the stream ends. The Manager resets it whenever it runs the task.
+func (t *Task) Usage() TokenUsage {
+	return t.usage.total()
+}
+
+// Wait blocks until the task's stream, if any, has ended and returns the
+// error it ended with. Result holds the streamed text afterwards.
+func (t *Task) Wait() error {
+	if t.Stream == nil {
+		return nil
+	}
+	t.Stream.Wait()
+	if err := t.Stream.Err(); err != nil {
+		return fmt.Errorf("stream of task %s failed: %w", t.ID, err)
+	}
 	return nil
 }
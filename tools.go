@@ -1,9 +1,8 @@
 package aicraft
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -28,16 +27,63 @@ type OpenAIResponse struct {
 }
 
 type Tool struct {
-	ID      string
-	Name    string
+	ID   string
+	Name string
+	// Inputs documents the inputs the tool reads.
+	Inputs []ToolInput
+	// Execute runs the tool. Tools that should stop when their run is
+	// cancelled, or that trace and rate limit their requests, set
+	// ExecuteContext instead.
 	Execute func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error)
+	// ExecuteContext runs the tool with the context of its task, which ends
+	// when the run is cancelled. It takes precedence over Execute.
+	ExecuteContext func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error)
+	// Estimate returns the usage the tool would have with inputs, priced
+	// with prices, for Manager.Plan. Plans mark tasks whose tool has no
+	// Estimate as partial.
+	Estimate func(inputs map[string]interface{}, prices PriceTable) UsageEstimate
+	// Placeholder, when set, returns the result of the tool in a dry run.
+	Placeholder func(inputs map[string]interface{}) interface{}
+	// Check, when set, reports whether the tool can run on this host, e.g.
+	// that a program it needs is installed. Workflows with tasks using a
+	// tool whose Check fails do not validate.
+	Check func() error
+}
+
+// execute runs ExecuteContext, or Execute unless ctx has already ended.
+func (t *Tool) execute(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+	if t.ExecuteContext != nil {
+		return t.ExecuteContext(ctx, inputs)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return t.Execute(inputs)
+}
+
+type ToolInput struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// clientInputs are the inputs of the tools that call the provider.
+var clientInputs = []ToolInput{
+	{Name: "api_key", Type: "string", Description: "provider API key; required unless the Manager's client has one"},
+	{Name: "base_url", Type: "string", Description: "provider base URL"},
+	{Name: "verbose", Type: "bool", Description: "log progress"},
 }
 
 var (
 	TextToPDFTool = &Tool{
 		ID:   "text_to_pdf",
 		Name: "Text to PDF",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		Inputs: []ToolInput{
+			{Name: "text", Type: "string", Required: true, Description: "text to convert"},
+		},
+		Estimate: noUsage,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
 			text, ok := inputs["text"].(string)
 			if !ok {
 				return nil, nil, fmt.Errorf("input 'text' is required and must be a string")
@@ -50,41 +96,61 @@ var (
 	OpenAIContentGeneratorTool = &Tool{
 		ID:   "openai_content_generator",
 		Name: "OpenAI Content Generator",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		Inputs: append([]ToolInput{
+			{Name: "query", Type: "string", Required: true, Description: "question or instruction"},
+			{Name: "context", Type: "string", Description: "context text; required unless images are given"},
+			{Name: "chunkSize", Type: "int", Description: "context chunk size; required with context"},
+			{Name: "chunkOverlap", Type: "int", Description: "context chunk overlap; required with context"},
+			{Name: "images", Type: "images", Description: "image URLs, paths or images to attach"},
+			{Name: "image_detail", Type: "string", Description: "vision detail level"},
+			{Name: "model", Type: "string", Description: "chat model, default gpt-3.5-turbo or gpt-4o with images"},
+			{Name: "temperature", Type: "float", Description: "sampling temperature; 0 makes the completion cacheable"},
+		}, clientInputs...),
+		Estimate: estimateChat,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
 			query, ok := inputs["query"].(string)
 			if !ok {
 				return nil, nil, fmt.Errorf("input 'query' is required and must be a string")
 			}
-			chunkSize, ok := inputs["chunkSize"].(int)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'chunkSize' is required and must be an int")
-			}
-			chunkOverlap, ok := inputs["chunkOverlap"].(int)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'chunkOverlap' is required and must be an int")
-			}
-
-			context, ok := inputs["context"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'context' is required and must be a string")
-			}
-			fmt.Println(context)
-			apiKey, ok := inputs["api_key"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'api_key' is required and must be a string")
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
 			}
 
 			// Retrieve the verbose flag
 			verbose, _ := inputs["verbose"].(bool)
 
+			imageURLs, err := imageURLsFromInput(inputs["images"])
+			if err != nil {
+				return nil, nil, err
+			}
+
 			model := "gpt-3.5-turbo"
+			if len(imageURLs) > 0 {
+				model = "gpt-4o"
+			}
 			if m, ok := inputs["model"].(string); ok && m != "" {
 				model = m
 			}
 
-			contextChunks := SplitTextIntoChunks(context, chunkSize, chunkOverlap)
+			prompt := query
+			if contextText, _ := inputs["context"].(string); contextText != "" {
+				chunkSize, ok := inputs["chunkSize"].(int)
+				if !ok {
+					return nil, nil, fmt.Errorf("input 'chunkSize' is required and must be an int")
+				}
+				chunkOverlap, ok := inputs["chunkOverlap"].(int)
+				if !ok {
+					return nil, nil, fmt.Errorf("input 'chunkOverlap' is required and must be an int")
+				}
 
-			prompt := fmt.Sprintf("Context: %s\n\nQuery: %s", contextChunks[0], query)
+				contextChunks := SplitTextIntoChunks(contextText, chunkSize, chunkOverlap)
+				if len(contextChunks) > 0 {
+					prompt = fmt.Sprintf("Context: %s\n\nQuery: %s", contextChunks[0], query)
+				}
+			} else if len(imageURLs) == 0 {
+				return nil, nil, fmt.Errorf("input 'context' is required and must be a string")
+			}
 			if EstimateTokens(prompt) > maxTokens {
 				prompt = truncateTextToTokenLimit(prompt, maxTokens-500)
 			}
@@ -93,162 +159,45 @@ var (
 				log.Printf("Generated prompt: %s", prompt)
 			}
 
+			detail, _ := inputs["image_detail"].(string)
+
 			data := map[string]interface{}{
 				"model":  model,
 				"stream": true,
-				"messages": []map[string]string{
-					{"role": "user", "content": prompt},
+				"messages": []map[string]interface{}{
+					{"role": "user", "content": chatContent(prompt, imageURLs, detail)},
 				},
 			}
-
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to marshal request data: %v", err)
+			if temperature, ok := floatInput(inputs, "temperature"); ok {
+				data["temperature"] = temperature
 			}
 
-			req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create request: %v", err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
+			contentChannel, err := client.streamChat(ctx, data)
 			if err != nil {
-				return nil, nil, fmt.Errorf("failed to execute request: %v", err)
+				return nil, nil, err
 			}
 
-			contentChannel := make(chan interface{})
-
-			go func() {
-				defer resp.Body.Close()
-				defer close(contentChannel)
-
-				reader := bufio.NewReader(resp.Body)
-				for {
-					line, err := reader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						log.Printf("failed to read stream: %v", err)
-						break
-					}
-
-					if strings.TrimSpace(line) == "data: [DONE]" {
-						break
-					}
-
-					if strings.HasPrefix(line, "data: ") {
-						line = line[len("data: "):]
-					}
-
-					var streamResponse struct {
-						Choices []struct {
-							Delta struct {
-								Content string `json:"content"`
-							} `json:"delta"`
-						} `json:"choices"`
-					}
-
-					if err := json.Unmarshal([]byte(line), &streamResponse); err != nil {
-						continue
-					}
-
-					if len(streamResponse.Choices) > 0 {
-						content := streamResponse.Choices[0].Delta.Content
-
-						contentChannel <- content
-					}
-				}
-			}()
-
 			return nil, contentChannel, nil
 		},
 	}
 
-	ImageGeneratorTool = &Tool{
-		ID:   "image_generator",
-		Name: "Image Generator",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
-			description, ok := inputs["description"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'description' is required and must be a string")
-			}
-
-			apiKey, ok := inputs["api_key"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'api_key' is required and must be a string")
-			}
-
-			verbose, _ := inputs["verbose"].(bool)
-
-			data := map[string]interface{}{
-				"prompt": description,
-				"n":      1,
-				"size":   "1024x1024",
-			}
-
-			if verbose {
-				log.Printf("Generating image with description: %s", description)
-			}
-
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to marshal request data: %v", err)
-			}
-
-			req, err := http.NewRequest("POST", "https://api.openai.com/v1/images/generations", bytes.NewBuffer(jsonData))
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create request: %v", err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to execute request: %v", err)
-			}
-			defer resp.Body.Close()
-
-			var response struct {
-				Data []struct {
-					URL string `json:"url"`
-				} `json:"data"`
-			}
-			err = json.NewDecoder(resp.Body).Decode(&response)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to decode response: %v", err)
-			}
-
-			if len(response.Data) == 0 {
-				return nil, nil, fmt.Errorf("no images returned from OpenAI API")
-			}
-
-			if verbose {
-				log.Printf("Generated image URL: %s", response.Data[0].URL)
-			}
-
-			return response.Data[0].URL, nil, nil
-		},
-	}
-
 	QueryToEmbeddingTool = &Tool{
 		ID:   "query_to_embedding",
 		Name: "Query to Embedding",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		Inputs: append([]ToolInput{
+			{Name: "query", Type: "string", Required: true, Description: "text to embed"},
+			{Name: "model", Type: "string", Description: "embedding model, default text-embedding-ada-002"},
+		}, clientInputs...),
+		Estimate: estimateEmbedding,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
 			query, ok := inputs["query"].(string)
 			if !ok {
 				return nil, nil, fmt.Errorf("input 'query' is required and must be a string")
 			}
 
-			apiKey, ok := inputs["api_key"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'api_key' is required and must be a string")
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
 			}
 
 			// Retrieve the verbose flag
@@ -264,39 +213,17 @@ var (
 				"input": query,
 			}
 
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to marshal request data: %v", err)
-			}
-
-			req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create request: %v", err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-
 			if verbose {
 				log.Printf("Sending query to OpenAI Embedding API: %s", query)
 			}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to execute request: %v", err)
-			}
-			defer resp.Body.Close()
-
 			var response struct {
 				Data []struct {
 					Embedding []float64 `json:"embedding"`
 				} `json:"data"`
 			}
-
-			err = json.NewDecoder(resp.Body).Decode(&response)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to decode response: %v", err)
+			if err := client.postJSON(ctx, "/embeddings", data, &response); err != nil {
+				return nil, nil, err
 			}
 
 			if len(response.Data) == 0 {
@@ -314,7 +241,13 @@ var (
 	PDFToEmbeddingsTool = &Tool{
 		ID:   "pdf_to_embeddings",
 		Name: "PDF to Embeddings",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		Inputs: append([]ToolInput{
+			{Name: "pdf_content", Type: "string", Required: true, Description: "text to split and embed"},
+			{Name: "chunkSize", Type: "int", Required: true, Description: "chunk size in characters"},
+			{Name: "chunkOverlap", Type: "int", Required: true, Description: "chunk overlap in characters"},
+		}, clientInputs...),
+		Estimate: estimateDocumentEmbeddings,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
 			pdfContent, _ := inputs["pdf_content"].(string)
 			log.Println("PDF CONTENT LENGTH => " + fmt.Sprintf("%d", len(pdfContent)))
 			chunkSize, _ := inputs["chunkSize"].(int)
@@ -323,9 +256,9 @@ var (
 				return nil, nil, fmt.Errorf("input 'chunkOverlap' is required and must be an int")
 			}
 
-			apiKey, ok := inputs["api_key"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'api_key' is required and must be a string")
+			client, err := clientFromInputs(ctx, inputs)
+			if err != nil {
+				return nil, nil, err
 			}
 
 			verbose, _ := inputs["verbose"].(bool)
@@ -350,34 +283,13 @@ var (
 					"input": chunk,
 				}
 
-				jsonData, err := json.Marshal(data)
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to marshal request data: %v", err)
-				}
-
-				req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to create request: %v", err)
-				}
-
-				req.Header.Set("Content-Type", "application/json")
-				req.Header.Set("Authorization", "Bearer "+apiKey)
-
-				client := &http.Client{}
-				resp, err := client.Do(req)
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to execute request: %v", err)
-				}
-				defer resp.Body.Close()
-
 				var response struct {
 					Data []struct {
 						Embedding []float64 `json:"embedding"`
 					} `json:"data"`
 				}
-				err = json.NewDecoder(resp.Body).Decode(&response)
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to decode response: %v", err)
+				if err := client.postJSON(ctx, "/embeddings", data, &response); err != nil {
+					return nil, nil, err
 				}
 
 				if len(response.Data) == 0 {
@@ -408,7 +320,11 @@ var (
 	PDFExtractorTool = &Tool{
 		ID:   "pdf_extractor",
 		Name: "PDF Extractor",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+		Inputs: []ToolInput{
+			{Name: "pdf_url", Type: "string", Required: true, Description: "URL of the PDF"},
+		},
+		Estimate: noUsage,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
 			pdfURL, ok := inputs["pdf_url"].(string)
 			if !ok {
 				return nil, nil, fmt.Errorf("input 'pdf_url' is required and must be a string")
@@ -434,66 +350,43 @@ var (
 			return text, nil, nil
 		},
 	}
+)
 
-	ImageNeedCheckerTool = &Tool{
-		ID:   "image_need_checker",
-		Name: "Image Need Checker",
-		Execute: func(inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
-			content, ok := inputs["content"].(string)
-			if !ok {
-				return nil, nil, fmt.Errorf("input 'content' is required and must be a string")
-			}
+// floatInput reads a numeric input that may have been given as an int or a
+// float.
+func floatInput(inputs map[string]interface{}, key string) (float64, bool) {
+	switch v := inputs[key].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
 
-			apiKey, ok := inputs["api_key"].(string)
+func stringsInput(inputs map[string]interface{}, key string) ([]string, error) {
+	switch v := inputs[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
 			if !ok {
-				return nil, nil, fmt.Errorf("input 'api_key' is required and must be a string")
+				return nil, fmt.Errorf("input '%s' must be a list of strings", key)
 			}
-			model := "text-embedding-ada-002"
-			if m, ok := inputs["model"].(string); ok && m != "" {
-				model = m
-			}
-			data := map[string]interface{}{
-				"model": model,
-				"messages": []map[string]string{
-					{"role": "system", "content": "You are an assistant that identifies the need for diagrams or flowcharts in text."},
-					{"role": "user", "content": fmt.Sprintf("Given the following content, identify if any diagrams or flowcharts are needed and provide descriptions: %s. THE OUTPUT TO BE STRICTLY A SLICE OF STRINGS OR ARRAY OF STRINGS", content)},
-				},
-			}
-
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to marshal request data: %v", err)
-			}
-
-			req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create request: %v", err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to execute request: %v", err)
-			}
-			defer resp.Body.Close()
-
-			var response OpenAIResponse
-			err = json.NewDecoder(resp.Body).Decode(&response)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to decode response: %v", err)
-			}
-
-			if len(response.Choices) == 0 {
-				return nil, nil, fmt.Errorf("no choices returned from OpenAI API")
-			}
-
-			return response.Choices[0].Message.Content, nil, nil
-		},
+			values = append(values, s)
+		}
+		return values, nil
 	}
-)
+	return nil, fmt.Errorf("input '%s' must be a list of strings", key)
+}
 
 func CosineSimilarity(vec1, vec2 []float64) float64 {
 	var dotProduct, magA, magB float64
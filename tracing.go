@@ -0,0 +1,52 @@
+package aicraft
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DevMaan707/aicraft"
+
+// tracerFromContext returns a tracer from the provider of the span in ctx,
+// so that provider requests are traced by whichever provider the Manager
+// was configured with, falling back to the global provider.
+func tracerFromContext(ctx context.Context) trace.Tracer {
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span.TracerProvider().Tracer(instrumentationName)
+	}
+	return otel.Tracer(instrumentationName)
+}
+
+func startRequestSpan(ctx context.Context, req *http.Request, model string) (context.Context, trace.Span) {
+	ctx, span := tracerFromContext(ctx).Start(ctx, req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+			attribute.String("gen_ai.system", "openai"),
+		),
+	)
+	if model != "" {
+		span.SetAttributes(attribute.String("gen_ai.request.model", model))
+	}
+	return ctx, span
+}
+
+func setUsageAttributes(span trace.Span, usage *Usage) {
+	span.SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", usage.PromptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", usage.CompletionTokens),
+		attribute.Int("gen_ai.usage.total_tokens", usage.TotalTokens),
+	)
+}
+
+func spanError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
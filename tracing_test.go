@@ -0,0 +1,126 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceRecorder returns a tracer provider that keeps ended spans in memory.
+func traceRecorder(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider, recorder
+}
+
+// spansByName indexes the ended spans by name.
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestRunSpans(t *testing.T) {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/embeddings": func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, map[string]interface{}{
+				"data":  []map[string]interface{}{{"embedding": []float64{0.1, 0.2}}},
+				"usage": map[string]int{"prompt_tokens": 3, "total_tokens": 3},
+			})
+		},
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "embed", ToolID: QueryToEmbeddingTool.ID, Inputs: providerInputs(srv, map[string]interface{}{"query": "hello"})}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"embed"}}},
+	})
+	provider, recorder := traceRecorder(t)
+	m.TracerProvider = provider
+
+	if err := m.ExecuteWorkflow(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := spansByName(recorder)
+	workflow, agent, task, request := spans["workflow"], spans["agent a"], spans["task embed"], spans["POST /embeddings"]
+	for name, span := range map[string]sdktrace.ReadOnlySpan{"workflow": workflow, "agent": agent, "task": task, "request": request} {
+		if span == nil {
+			t.Fatalf("no %s span in %v", name, recorder.Ended())
+		}
+	}
+	if agent.Parent().SpanID() != workflow.SpanContext().SpanID() ||
+		task.Parent().SpanID() != agent.SpanContext().SpanID() ||
+		request.Parent().SpanID() != task.SpanContext().SpanID() {
+		t.Error("spans are not nested workflow > agent > task > request")
+	}
+	if request.SpanKind() != trace.SpanKindClient {
+		t.Errorf("request span kind = %v, want client", request.SpanKind())
+	}
+	if model := spanAttribute(request, "gen_ai.request.model").AsString(); model != "text-embedding-ada-002" {
+		t.Errorf("gen_ai.request.model = %q", model)
+	}
+	if tokens := spanAttribute(request, "gen_ai.usage.input_tokens").AsInt64(); tokens != 3 {
+		t.Errorf("gen_ai.usage.input_tokens = %d, want 3", tokens)
+	}
+	if status := spanAttribute(request, "http.response.status_code").AsInt64(); status != http.StatusOK {
+		t.Errorf("http.response.status_code = %d", status)
+	}
+	if runID := spanAttribute(workflow, "aicraft.run.id").AsString(); runID == "" {
+		t.Error("the workflow span has no run ID")
+	}
+}
+
+func TestFailedTaskSpans(t *testing.T) {
+	broken := funcTool("broken", func(inputs map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("no luck")
+	})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "t", ToolID: "broken", Retries: 1}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"t"}}},
+	}, broken)
+	provider, recorder := traceRecorder(t)
+	m.TracerProvider = provider
+
+	if err := m.ExecuteWorkflow(); err == nil {
+		t.Fatal("want the task's error")
+	}
+
+	spans := spansByName(recorder)
+	task := spans["task t"]
+	if task.Status().Code != codes.Error {
+		t.Errorf("task span status = %v, want an error", task.Status())
+	}
+	if attempts := spanAttribute(task, "aicraft.task.attempts").AsInt64(); attempts != 2 {
+		t.Errorf("aicraft.task.attempts = %d, want 2", attempts)
+	}
+	retries := 0
+	for _, event := range task.Events() {
+		if event.Name == "retry" {
+			retries++
+		}
+	}
+	if retries != 1 {
+		t.Errorf("got %d retry events, want 1", retries)
+	}
+	if spans["workflow"].Status().Code != codes.Error {
+		t.Error("the workflow span does not record the failure")
+	}
+}
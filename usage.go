@@ -0,0 +1,255 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrBudgetExceeded is returned by a workflow run that used more tokens or
+// cost more than Manager.Budget allows.
+var ErrBudgetExceeded = errors.New("workflow budget exceeded")
+
+// ModelPrice is the price of a model in USD per million tokens. Embedding
+// tokens are charged at the Prompt price. Models that are not billed by
+// token have the price of a standard image, of a million characters of
+// speech or of a minute of transcribed audio; Manager.Plan uses them.
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+	Image      float64
+	Characters float64
+	Minute     float64
+}
+
+// PriceTable maps model names to prices. A model without an exact entry
+// uses the entry with the longest matching prefix, so "gpt-4o" also prices
+// "gpt-4o-2024-08-06".
+type PriceTable map[string]ModelPrice
+
+// DefaultPrices holds the published prices of the models the tools use by
+// default. It is used by managers without their own Prices.
+var DefaultPrices = PriceTable{
+	"gpt-3.5-turbo":          {Prompt: 0.50, Completion: 1.50},
+	"gpt-4":                  {Prompt: 30, Completion: 60},
+	"gpt-4-turbo":            {Prompt: 10, Completion: 30},
+	"gpt-4o":                 {Prompt: 2.50, Completion: 10},
+	"gpt-4o-mini":            {Prompt: 0.15, Completion: 0.60},
+	"text-embedding-ada-002": {Prompt: 0.10},
+	"text-embedding-3-small": {Prompt: 0.02},
+	"text-embedding-3-large": {Prompt: 0.13},
+	"dall-e-2":               {Image: 0.020},
+	"dall-e-3":               {Image: 0.040},
+	"tts-1":                  {Characters: 15},
+	"tts-1-hd":               {Characters: 30},
+	"whisper-1":              {Minute: 0.006},
+}
+
+// Lookup returns the price of model.
+func (p PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return p[best], true
+}
+
+// Cost returns the cost in USD of usage by model, or 0 when the model has no
+// price.
+func (p PriceTable) Cost(model string, usage TokenUsage) float64 {
+	price, ok := p.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens+usage.EmbeddingTokens)*price.Prompt +
+		float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+// TokenUsage counts the tokens used by provider requests and their cost.
+type TokenUsage struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	EmbeddingTokens  int     `json:"embedding_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.EmbeddingTokens += other.EmbeddingTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// Budget limits the tokens and cost of a workflow run. Zero fields are not
+// limited.
+type Budget struct {
+	MaxTokens int
+	MaxCost   float64
+}
+
+func (b Budget) check(usage TokenUsage) error {
+	if b.MaxTokens > 0 && usage.TotalTokens > b.MaxTokens {
+		return fmt.Errorf("%w: used %d tokens, limit is %d", ErrBudgetExceeded, usage.TotalTokens, b.MaxTokens)
+	}
+	if b.MaxCost > 0 && usage.Cost > b.MaxCost {
+		return fmt.Errorf("%w: cost $%.4f, limit is $%.4f", ErrBudgetExceeded, usage.Cost, b.MaxCost)
+	}
+	return nil
+}
+
+// UsageReport is the token usage of a workflow run, in total and broken
+// down by agent, task and model.
+type UsageReport struct {
+	Total  TokenUsage            `json:"total"`
+	Agents map[string]TokenUsage `json:"agents"`
+	Tasks  map[string]TokenUsage `json:"tasks"`
+	Models map[string]TokenUsage `json:"models"`
+}
+
+// usageMeter accumulates the usage recorded in its context and passes it on
+// to its parent, so a task's usage also counts for its agent and workflow.
+// The root meter of a run holds the prices, the budget and the per-model
+// breakdown.
+type usageMeter struct {
+	mu     sync.Mutex
+	usage  TokenUsage
+	parent *usageMeter
+
+	prices PriceTable
+	budget Budget
+	models map[string]TokenUsage
+	// exceeded is called once when the budget is exceeded.
+	exceeded func(error)
+	err      error
+}
+
+func newRootMeter(prices PriceTable, budget Budget, exceeded func(error)) *usageMeter {
+	if prices == nil {
+		prices = DefaultPrices
+	}
+	return &usageMeter{prices: prices, budget: budget, models: make(map[string]TokenUsage), exceeded: exceeded}
+}
+
+type usageMeterKey struct{}
+
+func withUsageMeter(ctx context.Context, meter *usageMeter) context.Context {
+	return context.WithValue(ctx, usageMeterKey{}, meter)
+}
+
+func usageMeterFromContext(ctx context.Context) *usageMeter {
+	meter, _ := ctx.Value(usageMeterKey{}).(*usageMeter)
+	return meter
+}
+
+func (m *usageMeter) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage = TokenUsage{}
+}
+
+// attach makes usage recorded on m count for parent as well. It must be
+// called before requests are made with m's context.
+func (m *usageMeter) attach(parent *usageMeter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parent = parent
+}
+
+func (m *usageMeter) total() TokenUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+func (m *usageMeter) root() *usageMeter {
+	for m.parent != nil {
+		m = m.parent
+	}
+	return m
+}
+
+func (m *usageMeter) record(model string, usage TokenUsage) {
+	root := m.root()
+	prices := root.prices
+	if prices == nil {
+		prices = DefaultPrices
+	}
+	usage.Cost = prices.Cost(model, usage)
+
+	for meter := m; meter != nil; meter = meter.parent {
+		meter.mu.Lock()
+		meter.usage.Add(usage)
+		if meter.models != nil {
+			modelUsage := meter.models[model]
+			modelUsage.Add(usage)
+			meter.models[model] = modelUsage
+		}
+		var exceeded func(error)
+		if meter.err == nil && meter.exceeded != nil {
+			if meter.err = meter.budget.check(meter.usage); meter.err != nil {
+				exceeded = meter.exceeded
+			}
+		}
+		err := meter.err
+		meter.mu.Unlock()
+		if exceeded != nil {
+			exceeded(err)
+		}
+	}
+}
+
+// budgetErr returns the budget error of a root meter.
+func (m *usageMeter) budgetErr() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *usageMeter) modelUsage() map[string]TokenUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	models := make(map[string]TokenUsage, len(m.models))
+	for model, usage := range m.models {
+		models[model] = usage
+	}
+	return models
+}
+
+// recordUsage records the usage of a provider request on the span and the
+// meter in ctx. Embedding requests only report prompt tokens, which are
+// counted as embedding tokens.
+func recordUsage(ctx context.Context, model string, embedding bool, usage *Usage) {
+	setUsageAttributes(trace.SpanFromContext(ctx), usage)
+
+	meter := usageMeterFromContext(ctx)
+	if meter == nil {
+		return
+	}
+	counted := TokenUsage{
+		Requests:         1,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+	if embedding {
+		counted.EmbeddingTokens = usage.PromptTokens
+		counted.PromptTokens = 0
+	}
+	meter.record(model, counted)
+}
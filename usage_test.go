@@ -0,0 +1,125 @@
+package aicraft
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestPriceTableLookup(t *testing.T) {
+	tests := []struct {
+		model string
+		want  ModelPrice
+		ok    bool
+	}{
+		{"gpt-4o", DefaultPrices["gpt-4o"], true},
+		{"gpt-4o-2024-08-06", DefaultPrices["gpt-4o"], true},
+		{"gpt-4o-mini-2024-07-18", DefaultPrices["gpt-4o-mini"], true},
+		{"llama3", ModelPrice{}, false},
+	}
+	for _, test := range tests {
+		got, ok := DefaultPrices.Lookup(test.model)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Lookup(%q) = %+v, %v, want %+v, %v", test.model, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestPriceTableCost(t *testing.T) {
+	prices := PriceTable{"chat": {Prompt: 2, Completion: 4}}
+	cost := prices.Cost("chat", TokenUsage{PromptTokens: 1000, EmbeddingTokens: 500, CompletionTokens: 250})
+	if want := (1500*2 + 250*4) / 1e6; math.Abs(cost-want) > 1e-12 {
+		t.Errorf("Cost = %v, want %v", cost, want)
+	}
+	if cost := prices.Cost("other", TokenUsage{PromptTokens: 1000}); cost != 0 {
+		t.Errorf("Cost of an unpriced model = %v, want 0", cost)
+	}
+}
+
+// usageProvider serves embeddings using 3 tokens and chat completions
+// using 15.
+func usageProvider(t *testing.T) map[string]interface{} {
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/embeddings": func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, map[string]interface{}{
+				"data":  []map[string]interface{}{{"embedding": []float64{1}}},
+				"usage": map[string]int{"prompt_tokens": 3, "total_tokens": 3},
+			})
+		},
+		"/chat/completions": chatReply(`{"diagrams": []}`),
+	})
+	return providerInputs(srv, nil)
+}
+
+func TestRunUsage(t *testing.T) {
+	provider := usageProvider(t)
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "embed", ToolID: QueryToEmbeddingTool.ID, Inputs: withInputs(provider, map[string]interface{}{"query": "q"})},
+			{ID: "plan", ToolID: DiagramPlannerTool.ID, Inputs: withInputs(provider, map[string]interface{}{"content": "text"})},
+		},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"embed"}},
+			{ID: "b", DependsOn: []string{"a"}, Tasks: []string{"plan"}},
+		},
+	})
+	m.Prices = PriceTable{"text-embedding-ada-002": {Prompt: 1}, "gpt-3.5-turbo": {Prompt: 2, Completion: 4}}
+
+	run, _ := m.NewRun()
+	if err := run.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report := run.Usage()
+	total := report.Total
+	if total.Requests != 2 || total.EmbeddingTokens != 3 || total.PromptTokens != 10 || total.CompletionTokens != 5 || total.TotalTokens != 18 {
+		t.Errorf("Total = %+v", total)
+	}
+	if want := (3*1 + 10*2 + 5*4) / 1e6; math.Abs(total.Cost-want) > 1e-12 {
+		t.Errorf("Total.Cost = %v, want %v", total.Cost, want)
+	}
+	if report.Tasks["plan"].TotalTokens != 15 || report.Agents["a"].EmbeddingTokens != 3 {
+		t.Errorf("Tasks = %+v, Agents = %+v", report.Tasks, report.Agents)
+	}
+	if report.Models["gpt-3.5-turbo"].Requests != 1 || report.Models["text-embedding-ada-002"].Requests != 1 {
+		t.Errorf("Models = %+v", report.Models)
+	}
+}
+
+func TestBudgetStopsRun(t *testing.T) {
+	provider := usageProvider(t)
+	planInputs := withInputs(provider, map[string]interface{}{"content": "text"})
+	m := newTestManager(t, WorkflowConfig{
+		Tasks: []TaskConfig{
+			{ID: "first", ToolID: DiagramPlannerTool.ID, Inputs: planInputs},
+			{ID: "second", ToolID: DiagramPlannerTool.ID, Inputs: planInputs},
+		},
+		Agents: []AgentConfig{
+			{ID: "a", Tasks: []string{"first"}},
+			{ID: "b", DependsOn: []string{"a"}, Tasks: []string{"second"}},
+		},
+	})
+	m.Budget = Budget{MaxTokens: 10}
+
+	run, _ := m.NewRun()
+	err := run.Execute(context.Background())
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Execute() = %v, want ErrBudgetExceeded", err)
+	}
+	if run.Tasks["second"].Result != nil {
+		t.Error("a task ran after the budget was exceeded")
+	}
+}
+
+// withInputs returns a copy of base with inputs added.
+func withInputs(base, inputs map[string]interface{}) map[string]interface{} {
+	all := make(map[string]interface{}, len(base)+len(inputs))
+	for name, value := range base {
+		all[name] = value
+	}
+	for name, value := range inputs {
+		all[name] = value
+	}
+	return all
+}
@@ -0,0 +1,421 @@
+package aicraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/hhrutter/tiff"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	pdfmodel "github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+type PDFImage struct {
+	Page        int    `json:"page"`
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+var visionContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// pdfImageInputs are the inputs of the tools that return images of PDFs.
+var pdfImageInputs = []ToolInput{
+	{Name: "pdf_url", Type: "string", Description: "URL of the PDF; required without pdf_path"},
+	{Name: "pdf_path", Type: "string", Description: "local PDF file"},
+	{Name: "pages", Type: "string", Description: "page selection, e.g. 1-3,5"},
+	{Name: "output_dir", Type: "string", Description: "directory to write the images to"},
+}
+
+var (
+	// PDFPageImagesTool renders whole PDF pages to PNG images, charts and
+	// other vector drawings included, that can be passed to
+	// OpenAIContentGeneratorTool's 'images' input. Pages are rendered with
+	// pdftoppm from Poppler, which must be installed: pdfcpu cannot
+	// rasterize pages, and the renderer of unipdf needs a commercial
+	// license. Workflows using the tool do not validate without pdftoppm.
+	PDFPageImagesTool = &Tool{
+		ID:   "pdf_page_images",
+		Name: "PDF Page Images",
+		Inputs: append(append([]ToolInput(nil), pdfImageInputs...),
+			ToolInput{Name: "dpi", Type: "int", Description: "resolution, default 150"},
+		),
+		Estimate: noUsage,
+		Check:    checkPdftoppm,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			pdfPath, cleanup, err := pdfFromInputs(inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer cleanup()
+
+			dpi, _ := inputs["dpi"].(int)
+			if dpi <= 0 {
+				dpi = 150
+			}
+			selection, _ := inputs["pages"].(string)
+			images, err := RenderPDFPages(ctx, pdfPath, selection, dpi)
+			if err != nil {
+				return nil, nil, err
+			}
+			return finishPDFImages(inputs, images)
+		},
+	}
+
+	// PDFEmbeddedImagesTool extracts the raster images embedded in PDF
+	// pages, such as photos and scans, without rendering the pages. Vector
+	// drawings are not included; use PDFPageImagesTool for those.
+	PDFEmbeddedImagesTool = &Tool{
+		ID:       "pdf_embedded_images",
+		Name:     "PDF Embedded Images",
+		Inputs:   pdfImageInputs,
+		Estimate: noUsage,
+		ExecuteContext: func(ctx context.Context, inputs map[string]interface{}) (interface{}, <-chan interface{}, error) {
+			pdfPath, cleanup, err := pdfFromInputs(inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer cleanup()
+
+			var pages []string
+			if selection, ok := inputs["pages"].(string); ok && selection != "" {
+				pages = strings.Split(selection, ",")
+			}
+
+			images, err := ExtractImagesFromPDF(pdfPath, pages)
+			if err != nil {
+				return nil, nil, err
+			}
+			return finishPDFImages(inputs, images)
+		},
+	}
+)
+
+// pdfFromInputs returns the local path of the PDF given by the 'pdf_path'
+// or 'pdf_url' input and a function removing it when it was downloaded.
+func pdfFromInputs(inputs map[string]interface{}) (string, func(), error) {
+	if pdfPath, _ := inputs["pdf_path"].(string); pdfPath != "" {
+		return pdfPath, func() {}, nil
+	}
+	pdfURL, ok := inputs["pdf_url"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("input 'pdf_url' or 'pdf_path' is required and must be a string")
+	}
+	downloaded, err := DownloadPDF(pdfURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download PDF: %v", err)
+	}
+	return downloaded, func() { os.Remove(downloaded) }, nil
+}
+
+// finishPDFImages writes images to the 'output_dir' input, if given,
+// returning their paths instead of their data.
+func finishPDFImages(inputs map[string]interface{}, images []PDFImage) (interface{}, <-chan interface{}, error) {
+	verbose, _ := inputs["verbose"].(bool)
+	outputDir, _ := inputs["output_dir"].(string)
+
+	for i := range images {
+		if outputDir == "" {
+			continue
+		}
+		if err := os.MkdirAll(outputDir, 0o755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+		ext := mimetype.Lookup(images[i].ContentType).Extension()
+		path := filepath.Join(outputDir, fmt.Sprintf("page-%d-%s%s", images[i].Page, images[i].Name, ext))
+		if err := os.WriteFile(path, images[i].Data, 0o644); err != nil {
+			return nil, nil, fmt.Errorf("failed to write image: %v", err)
+		}
+		images[i].Path = path
+		images[i].Data = nil
+	}
+
+	if verbose {
+		log.Printf("Extracted %d images from PDF", len(images))
+	}
+
+	return images, nil, nil
+}
+
+// pdftoppm is the command RenderPDFPages runs.
+var pdftoppm = "pdftoppm"
+
+// checkPdftoppm returns an error when pdftoppm is not installed.
+func checkPdftoppm() error {
+	if _, err := exec.LookPath(pdftoppm); err != nil {
+		return fmt.Errorf("rendering PDF pages needs pdftoppm from Poppler: %w", err)
+	}
+	return nil
+}
+
+// RenderPDFPages renders the selected pages of the PDF at path to PNG
+// images at dpi, all pages when selection is empty. A selection lists pages
+// and ranges, e.g. 1-3,5. It runs pdftoppm from Poppler.
+func RenderPDFPages(ctx context.Context, path, selection string, dpi int) ([]PDFImage, error) {
+	if err := checkPdftoppm(); err != nil {
+		return nil, err
+	}
+	ranges, err := pageRanges(selection)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "aicraft-pages-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var images []PDFImage
+	for i, pages := range ranges {
+		prefix := filepath.Join(dir, fmt.Sprintf("r%d", i))
+		args := []string{"-png", "-r", strconv.Itoa(dpi)}
+		if pages[0] > 0 {
+			args = append(args, "-f", strconv.Itoa(pages[0]), "-l", strconv.Itoa(pages[1]))
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, pdftoppm, append(args, path, prefix)...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to render PDF pages: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		files, err := filepath.Glob(prefix + "-*.png")
+		if err != nil {
+			return nil, err
+		}
+		var rendered []PDFImage
+		for _, file := range files {
+			page, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, prefix+"-"), ".png"))
+			if err != nil {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			img := PDFImage{Page: page, Name: "rendered", ContentType: "image/png", Data: data}
+			if cfg, err := png.DecodeConfig(bytes.NewReader(data)); err == nil {
+				img.Width, img.Height = cfg.Width, cfg.Height
+			}
+			rendered = append(rendered, img)
+		}
+		sort.Slice(rendered, func(i, j int) bool { return rendered[i].Page < rendered[j].Page })
+		images = append(images, rendered...)
+	}
+	return images, nil
+}
+
+// pageRanges parses a page selection into first and last pages. An empty
+// selection is one range of {0, 0}, all pages.
+func pageRanges(selection string) ([][2]int, error) {
+	if strings.TrimSpace(selection) == "" {
+		return [][2]int{{0, 0}}, nil
+	}
+	var ranges [][2]int
+	for _, part := range strings.Split(selection, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 1 || to < from {
+			return nil, fmt.Errorf("invalid page selection %q, want pages and ranges like 1-3,5", selection)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+// ExtractImagesFromPDF returns the images embedded in the selected pages
+// (pdfcpu page selection syntax, all pages when empty) in a format the
+// vision models accept. TIFF images are converted to PNG and other
+// unsupported formats are skipped.
+func ExtractImagesFromPDF(path string, pages []string) ([]PDFImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF file: %w", err)
+	}
+	defer f.Close()
+
+	conf := pdfmodel.NewDefaultConfiguration()
+	var images []PDFImage
+	err = api.ExtractImages(f, pages, func(img pdfmodel.Image, _ bool, _ int) error {
+		data, err := io.ReadAll(img)
+		if err != nil {
+			return fmt.Errorf("failed to read image %s on page %d: %w", img.Name, img.PageNr, err)
+		}
+
+		contentType := mimetype.Detect(data).String()
+		if contentType == "image/tiff" {
+			data, err = tiffToPNG(data)
+			if err != nil {
+				return fmt.Errorf("failed to convert image %s on page %d: %w", img.Name, img.PageNr, err)
+			}
+			contentType = "image/png"
+		}
+		if !visionContentTypes[contentType] {
+			return nil
+		}
+
+		width, height := img.Width, img.Height
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+
+		images = append(images, PDFImage{
+			Page:        img.PageNr,
+			Name:        img.Name,
+			ContentType: contentType,
+			Width:       width,
+			Height:      height,
+			Data:        data,
+		})
+		return nil
+	}, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract images from PDF: %w", err)
+	}
+	return images, nil
+}
+
+func tiffToPNG(data []byte) ([]byte, error) {
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func dataURL(data []byte) string {
+	return "data:" + mimetype.Detect(data).String() + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// hasImages reports whether the 'images' input holds any images, without
+// reading them.
+func hasImages(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+		return v.Len() > 0
+	}
+	return true
+}
+
+// imageURLsFromInput converts the 'images' input into URLs for the chat API.
+// Items may be http(s) or data URLs, local file paths, GeneratedImage or
+// PDFImage values, or slices of any of these.
+func imageURLsFromInput(value interface{}) ([]string, error) {
+	var urls []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") || strings.HasPrefix(v, "data:") {
+			return []string{v}, nil
+		}
+		data, err := os.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s: %v", v, err)
+		}
+		return []string{dataURL(data)}, nil
+	case GeneratedImage:
+		switch {
+		case v.Path != "":
+			return imageURLsFromInput(v.Path)
+		case v.B64JSON != "":
+			return []string{v.dataURL()}, nil
+		case v.URL != "":
+			return []string{v.URL}, nil
+		}
+		return nil, fmt.Errorf("input 'images' contains an image without path, data or URL")
+	case PDFImage:
+		if v.Path != "" {
+			return imageURLsFromInput(v.Path)
+		}
+		return []string{dataURL(v.Data)}, nil
+	case []string:
+		for _, item := range v {
+			u, err := imageURLsFromInput(item)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, u...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			u, err := imageURLsFromInput(item)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, u...)
+		}
+	case []GeneratedImage:
+		for _, item := range v {
+			u, err := imageURLsFromInput(item)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, u...)
+		}
+	case []PDFImage:
+		for _, item := range v {
+			u, err := imageURLsFromInput(item)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, u...)
+		}
+	default:
+		return nil, fmt.Errorf("input 'images' must be a URL, a file path, an image or a list of them")
+	}
+	return urls, nil
+}
+
+// chatContent returns a plain string message content, or text and image
+// parts when images are attached.
+func chatContent(text string, imageURLs []string, detail string) interface{} {
+	if len(imageURLs) == 0 {
+		return text
+	}
+	parts := []map[string]interface{}{
+		{"type": "text", "text": text},
+	}
+	for _, url := range imageURLs {
+		imageURL := map[string]string{"url": url}
+		if detail != "" {
+			imageURL["detail"] = detail
+		}
+		parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": imageURL})
+	}
+	return parts
+}
@@ -0,0 +1,233 @@
+package aicraft
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func TestPageRanges(t *testing.T) {
+	tests := []struct {
+		selection string
+		want      [][2]int
+	}{
+		{"", [][2]int{{0, 0}}},
+		{"3", [][2]int{{3, 3}}},
+		{"1-3, 5", [][2]int{{1, 3}, {5, 5}}},
+	}
+	for _, test := range tests {
+		got, err := pageRanges(test.selection)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pageRanges(%q) = %v, %v, want %v", test.selection, got, err, test.want)
+		}
+	}
+	for _, selection := range []string{"0", "3-1", "a", "1-", "1,,2"} {
+		if _, err := pageRanges(selection); err == nil {
+			t.Errorf("pageRanges(%q) succeeded, want an error", selection)
+		}
+	}
+}
+
+// fakePdftoppm replaces pdftoppm with a script that writes png for every
+// page from -f to -l, or pages 1 and 2, and records its arguments.
+func fakePdftoppm(t *testing.T, png []byte) (argsFile string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pdftoppm is a shell script")
+	}
+	dir := t.TempDir()
+	pngFile := filepath.Join(dir, "page.png")
+	argsFile = filepath.Join(dir, "args")
+	if err := os.WriteFile(pngFile, png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+echo "$@" >> ` + argsFile + `
+first=1 last=2
+while [ $# -gt 2 ]; do
+	case "$1" in
+	-f) first=$2; shift ;;
+	-l) last=$2; shift ;;
+	esac
+	shift
+done
+[ -f "$1" ] || { echo "missing $1" >&2; exit 1; }
+page=$first
+while [ $page -le $last ]; do
+	cp ` + pngFile + ` "$2-$page.png"
+	page=$((page + 1))
+done
+`
+	path := filepath.Join(dir, "pdftoppm")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	previous := pdftoppm
+	pdftoppm = path
+	t.Cleanup(func() { pdftoppm = previous })
+	return argsFile
+}
+
+func TestRenderPDFPages(t *testing.T) {
+	data := testPNG(t)
+	argsFile := fakePdftoppm(t, data)
+	pdf := filepath.Join(t.TempDir(), "doc.pdf")
+	os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644)
+
+	images, err := RenderPDFPages(context.Background(), pdf, "2-3,5", 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []int
+	for _, img := range images {
+		pages = append(pages, img.Page)
+		if img.Name != "rendered" || img.ContentType != "image/png" || img.Width != 2 || !bytes.Equal(img.Data, data) {
+			t.Errorf("image = %+v", img)
+		}
+	}
+	if !reflect.DeepEqual(pages, []int{2, 3, 5}) {
+		t.Errorf("pages = %v, want [2 3 5]", pages)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "-png -r 72 -f 2 -l 3 "+pdf) {
+		t.Errorf("pdftoppm was run with %q", args)
+	}
+
+	if _, err := RenderPDFPages(context.Background(), filepath.Join(t.TempDir(), "missing.pdf"), "", 72); err == nil {
+		t.Error("want an error when pdftoppm fails")
+	}
+}
+
+func TestPDFPageImagesNeedsPdftoppm(t *testing.T) {
+	config := WorkflowConfig{
+		Tasks:  []TaskConfig{{ID: "pages", ToolID: PDFPageImagesTool.ID}},
+		Agents: []AgentConfig{{ID: "a", Tasks: []string{"pages"}}},
+	}
+	previous := pdftoppm
+	pdftoppm = filepath.Join(t.TempDir(), "missing")
+	err := NewManager().ValidateWorkflow(config)
+	pdftoppm = previous
+	if err == nil || !strings.Contains(err.Error(), "pdftoppm") {
+		t.Errorf("ValidateWorkflow() without pdftoppm = %v", err)
+	}
+
+	fakePdftoppm(t, testPNG(t))
+	if err := NewManager().ValidateWorkflow(config); err != nil {
+		t.Errorf("ValidateWorkflow() with pdftoppm = %v", err)
+	}
+}
+
+func TestPDFPageImagesToolWritesOutputDir(t *testing.T) {
+	fakePdftoppm(t, testPNG(t))
+	pdf := filepath.Join(t.TempDir(), "doc.pdf")
+	os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644)
+	out := t.TempDir()
+
+	result, _, err := PDFPageImagesTool.ExecuteContext(context.Background(), map[string]interface{}{"pdf_path": pdf, "output_dir": out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := result.([]PDFImage)
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2", len(images))
+	}
+	for _, img := range images {
+		if img.Data != nil || filepath.Dir(img.Path) != out {
+			t.Errorf("image = %+v, want it written to %s", img, out)
+		}
+	}
+	if want := filepath.Join(out, "page-2-rendered.png"); images[1].Path != want {
+		t.Errorf("Path = %q, want %q", images[1].Path, want)
+	}
+}
+
+func TestExtractImagesFromPDF(t *testing.T) {
+	dir := t.TempDir()
+	imageFile := filepath.Join(dir, "figure.png")
+	if err := os.WriteFile(imageFile, testPNG(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pdf := filepath.Join(dir, "doc.pdf")
+	if err := api.ImportImagesFile([]string{imageFile}, pdf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := PDFEmbeddedImagesTool.ExecuteContext(context.Background(), map[string]interface{}{"pdf_path": pdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := result.([]PDFImage)
+	if len(images) != 1 || images[0].Page != 1 || images[0].ContentType != "image/png" || images[0].Width != 2 {
+		t.Errorf("images = %+v", images)
+	}
+}
+
+func TestImageURLsFromInput(t *testing.T) {
+	data := testPNG(t)
+	path := filepath.Join(t.TempDir(), "chart.png")
+	os.WriteFile(path, data, 0o644)
+	encoded := dataURL(data)
+
+	urls, err := imageURLsFromInput([]interface{}{
+		"https://images.test/a.png",
+		path,
+		GeneratedImage{B64JSON: "AAAA"},
+		[]PDFImage{{Data: data}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://images.test/a.png", encoded, "data:image/png;base64,AAAA", encoded}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("urls = %v, want %v", urls, want)
+	}
+	if !strings.HasPrefix(encoded, "data:image/png;base64,") {
+		t.Errorf("dataURL() = %q", encoded)
+	}
+	if _, err := imageURLsFromInput(42); err == nil {
+		t.Error("want an error for an unsupported input")
+	}
+}
+
+func TestContentGeneratorAttachesImages(t *testing.T) {
+	var request map[string]interface{}
+	srv := newFakeProvider(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, r *http.Request) {
+			request = decodeTestJSON(t, r)
+			streamReply("a bar ", "chart")(w, r)
+		},
+	})
+
+	_, stream, err := OpenAIContentGeneratorTool.ExecuteContext(context.Background(), providerInputs(srv, map[string]interface{}{
+		"query":        "What does the figure show?",
+		"images":       "https://images.test/figure.png",
+		"image_detail": "high",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := readStream(stream)
+	if err != nil || text != "a bar chart" {
+		t.Errorf("stream = %q, %v", text, err)
+	}
+
+	if request["model"] != "gpt-4o" {
+		t.Errorf("model = %v, want gpt-4o for images", request["model"])
+	}
+	content := request["messages"].([]interface{})[0].(map[string]interface{})["content"].([]interface{})
+	if len(content) != 2 {
+		t.Fatalf("content = %v, want text and one image", content)
+	}
+	image := content[1].(map[string]interface{})["image_url"].(map[string]interface{})
+	if image["url"] != "https://images.test/figure.png" || image["detail"] != "high" {
+		t.Errorf("image part = %v", image)
+	}
+}